@@ -44,7 +44,7 @@ func bootstrap(node *jamestypes.Node, jamesfile *jamestypes.JamesfileCtx) error
 			`docker swarm init --advertise-addr %s --listen-addr %s
 
 # for some reason we've to opt-in for encryption..
-docker network create --driver overlay --opt encrypted --attachable fn61
+docker network create --driver overlay --opt encrypted --attachable %s
 
 SERVERCERT_KEY="%s"
 DOCKERSOCKPROXY_VERSION="%s"
@@ -56,14 +56,16 @@ docker service create \
 	--publish 4431:4431 \
 	--env "SERVERCERT_KEY=$SERVERCERT_KEY" \
 	--mount type=bind,src=/var/run/docker.sock,dst=/var/run/docker.sock \
-	--network fn61 \
+	--network %s \
 	"fn61/dockersockproxy:$DOCKERSOCKPROXY_VERSION"
 
 `,
 			node.Addr,
 			node.Addr,
+			dockerNetworkName(jamesfile.Cluster),
 			jamesfile.File.DockerSockProxyServerCertKey,
-			jamesfile.File.DockerSockProxyVersion)
+			jamesfile.File.DockerSockProxyVersion,
+			dockerNetworkName(jamesfile.Cluster))
 
 		swarmInitCmd = commands.AddPart(script)
 	} else {
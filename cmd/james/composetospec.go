@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/function61/gokit/osutil"
+	"github.com/function61/james/pkg/servicespec"
+	"github.com/spf13/cobra"
+)
+
+func composeToSpecEntry() *cobra.Command {
+	return &cobra.Command{
+		Use:   "compose-to-spec <file.yml>",
+		Short: "Compose (YAML) to spec (HCL), for importing legacy stacks",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			composeContent, err := ioutil.ReadFile(args[0])
+			osutil.ExitIfError(err)
+
+			specContent, unsupported, err := servicespec.ComposeToSpec(composeContent)
+			osutil.ExitIfError(err)
+
+			fmt.Printf("%s", specContent)
+
+			// stderr so that stdout can be redirected to a file
+			for _, item := range unsupported {
+				fmt.Fprintf(os.Stderr, "NOT CONVERTED: %s\n", item)
+			}
+		},
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/function61/gokit/osutil"
+	"github.com/scylladb/termtables"
+	"github.com/spf13/cobra"
+)
+
+// Portainer only stores the current compose of a stack, so we keep a local append-only log
+// (one JSON line per deploy) for each james ref, to be able to see and roll back earlier deploys.
+
+type deployHistoryAction string
+
+const (
+	deployHistoryActionCreate   deployHistoryAction = "create"
+	deployHistoryActionUpdate   deployHistoryAction = "update"
+	deployHistoryActionRollback deployHistoryAction = "rollback"
+)
+
+type deployHistoryEntry struct {
+	Time       time.Time           `json:"time"`
+	Action     deployHistoryAction `json:"action"`
+	JamesRef   string              `json:"james_ref"`
+	SpecSha256 string              `json:"spec_sha256"` // of the spec that the new compose was made from
+	DeployedBy string              `json:"deployed_by"` // "joonas@laptop"
+	Previous   string              `json:"previous"`    // compose before deploy ("" for create)
+	New        string              `json:"new"`
+}
+
+var deployHistoryFilenameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// "prod5:stacks/hellohttp.hcl" => "deploy-history/prod5_stacks_hellohttp.hcl.jsonl".
+// not unique ("a_b.hcl" and "a/b.hcl" share a file), so readers filter by james ref
+func deployHistoryPath(jamesRef string) string {
+	return filepath.Join(
+		deployHistoryDir,
+		deployHistoryFilenameInvalidChars.ReplaceAllString(jamesRef, "_")+".jsonl")
+}
+
+func appendDeployHistory(entry deployHistoryEntry) error {
+	historyPath := deployHistoryPath(entry.JamesRef)
+
+	if err := os.MkdirAll(filepath.Dir(historyPath), 0755); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// oldest first. empty if no deploys recorded
+func readDeployHistory(jamesRef string) ([]deployHistoryEntry, error) {
+	file, err := os.Open(deployHistoryPath(jamesRef))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []deployHistoryEntry{}, nil
+		}
+
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []deployHistoryEntry{}
+
+	lines := bufio.NewScanner(file)
+	lines.Buffer(nil, 16*1024*1024) // compose files can be bigger than default max line length
+
+	for lineNumber := 1; lines.Scan(); lineNumber++ {
+		entry := deployHistoryEntry{}
+		if err := json.Unmarshal(lines.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", deployHistoryPath(jamesRef), lineNumber, err)
+		}
+
+		if entry.JamesRef != jamesRef { // another ref that maps to the same file
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, lines.Err()
+}
+
+// history is for humans, so failing to write it is not worth failing an already-done deploy for
+func recordDeploy(action deployHistoryAction, jamesRef string, specSha256 string, previous string, updated string) {
+	if err := appendDeployHistory(deployHistoryEntry{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		JamesRef:   jamesRef,
+		SpecSha256: specSha256,
+		DeployedBy: deployerIdentity(),
+		Previous:   previous,
+		New:        updated,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "WARN: failed to record deploy history: %v\n", err)
+	}
+}
+
+func specFileSha256(specPath string) (string, error) {
+	content, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(content)), nil
+}
+
+// "joonas@laptop"
+func deployerIdentity() string {
+	username := "unknown"
+	if current, err := user.Current(); err == nil {
+		username = current.Username
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return username + "@" + hostname
+}
+
+func stackHistory(specPath string) error {
+	jctx, err := readJamesfile()
+	if err != nil {
+		return err
+	}
+
+	jamesRef, err := makeJamesRef(jctx, specPath)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readDeployHistory(jamesRef)
+	if err != nil {
+		return err
+	}
+
+	tbl := termtables.CreateTable()
+	tbl.AddHeaders("#", "Time", "Action", "Deployed by", "Spec hash")
+
+	for idx, entry := range entries {
+		tbl.AddRow(
+			strconv.Itoa(idx+1),
+			entry.Time.Format(time.RFC3339),
+			string(entry.Action),
+			entry.DeployedBy,
+			shortHash(entry.SpecSha256))
+	}
+
+	fmt.Println(tbl.Render())
+
+	return nil
+}
+
+// to = 0 means the compose that was deployed before the latest deploy
+func stackRollback(specPath string, to int) error {
+	ctx := context.TODO()
+
+	jctx, err := readJamesfile()
+	if err != nil {
+		return err
+	}
+
+	jamesRef, err := makeJamesRef(jctx, specPath)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readDeployHistory(jamesRef)
+	if err != nil {
+		return err
+	}
+
+	target, err := rollbackTarget(entries, to)
+	if err != nil {
+		return err
+	}
+
+	portainer, err := makePortainerClient2(ctx, *jctx)
+	if err != nil {
+		return err
+	}
+
+	stacks, err := portainer.ListStacks(ctx)
+	if err != nil {
+		return err
+	}
+
+	stack := findPortainerStackByRef(jamesRef, jctx.Cluster.PortainerEndpointId, stacks)
+	if stack == nil {
+		return fmt.Errorf("stack by JAMES_REF=%s not found", jamesRef)
+	}
+
+	stackId := strconv.Itoa(stack.Id)
+
+	previous, err := portainer.StackFile(ctx, stackId)
+	if err != nil {
+		return err
+	}
+
+	if previous == target.compose {
+		return errors.New("deployed stack already matches the rollback target")
+	}
+
+	printStackDiff(previous, target.compose)
+
+	if err := askForAck("rollback"); err != nil {
+		return err
+	}
+
+	if err := portainer.UpdateStack(ctx, stackId, jamesRef, target.compose); err != nil {
+		return err
+	}
+
+	recordDeploy(deployHistoryActionRollback, jamesRef, target.specSha256, previous, target.compose)
+
+	fmt.Println("✓ rolled back. remember to revert the spec as well, or the next deploy undoes this")
+
+	return nil
+}
+
+type rollbackCompose struct {
+	compose    string
+	specSha256 string
+}
+
+// to = N means "state after entry N" (numbered from 1, as in stack history)
+func rollbackTarget(entries []deployHistoryEntry, to int) (*rollbackCompose, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("no deploy history")
+	}
+
+	if to == 0 {
+		latest := entries[len(entries)-1]
+
+		// "previous" of a rollback is what we just rolled back from
+		if latest.Action == deployHistoryActionRollback {
+			return nil, errors.New("latest deploy was a rollback; use --to to choose the entry to roll back to")
+		}
+
+		if latest.Previous == "" {
+			return nil, errors.New("latest deploy created the stack; nothing to roll back to")
+		}
+
+		// spec hash of the previous is only known if it was deployed by us
+		specSha256 := ""
+		if len(entries) >= 2 {
+			specSha256 = entries[len(entries)-2].SpecSha256
+		}
+
+		return &rollbackCompose{compose: latest.Previous, specSha256: specSha256}, nil
+	}
+
+	if to < 1 || to > len(entries) {
+		return nil, fmt.Errorf("--to must be in range 1-%d", len(entries))
+	}
+
+	entry := entries[to-1]
+
+	return &rollbackCompose{compose: entry.New, specSha256: entry.SpecSha256}, nil
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+
+	return hash
+}
+
+func stackHistoryEntry() *cobra.Command {
+	return &cobra.Command{
+		Use:   "history <path to .hcl>",
+		Short: "Lists deploys of a stack (recorded locally)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			osutil.ExitIfError(stackHistory(args[0]))
+		},
+	}
+}
+
+func stackRollbackEntry() *cobra.Command {
+	to := 0
+
+	cmd := &cobra.Command{
+		Use:   "rollback <path to .hcl>",
+		Short: "Re-deploys an earlier compose of a stack (from its deploy history)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			osutil.ExitIfError(stackRollback(args[0], to))
+		},
+	}
+
+	cmd.Flags().IntVarP(&to, "to", "", to, "History entry # to roll back to (default: the one before latest deploy, unless it was a rollback)")
+
+	return cmd
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestDeployHistoryPath(t *testing.T) {
+	assert.EqualString(t, deployHistoryPath("prod5:stacks/hellohttp.hcl"), "deploy-history/prod5_stacks_hellohttp.hcl.jsonl")
+}
+
+func TestDeployHistoryRoundtrip(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "deployhistory")
+	assert.Assert(t, err == nil)
+	defer os.RemoveAll(tempDir)
+
+	wd, err := os.Getwd()
+	assert.Assert(t, err == nil)
+	defer func() { _ = os.Chdir(wd) }()
+	assert.Assert(t, os.Chdir(tempDir) == nil)
+
+	entries, err := readDeployHistory("prod5:stacks/hellohttp.hcl")
+	assert.Assert(t, err == nil)
+	assert.Assert(t, len(entries) == 0)
+
+	recordDeploy(deployHistoryActionCreate, "prod5:stacks/hellohttp.hcl", "aaa", "", "v1")
+	recordDeploy(deployHistoryActionUpdate, "prod5:stacks/hellohttp.hcl", "bbb", "v1", "v2")
+
+	entries, err = readDeployHistory("prod5:stacks/hellohttp.hcl")
+	assert.Assert(t, err == nil)
+	assert.Assert(t, len(entries) == 2)
+	assert.EqualString(t, string(entries[1].Action), "update")
+	assert.EqualString(t, entries[1].Previous, "v1")
+	assert.EqualString(t, entries[1].New, "v2")
+
+	// shares the file, but is not the same stack
+	recordDeploy(deployHistoryActionCreate, "prod5:stacks_hellohttp.hcl", "ccc", "", "other")
+
+	entries, err = readDeployHistory("prod5:stacks/hellohttp.hcl")
+	assert.Assert(t, err == nil)
+	assert.Assert(t, len(entries) == 2)
+}
+
+func TestRollbackTarget(t *testing.T) {
+	entries := []deployHistoryEntry{
+		{Action: deployHistoryActionCreate, SpecSha256: "aaa", Previous: "", New: "v1"},
+		{Action: deployHistoryActionUpdate, SpecSha256: "bbb", Previous: "v1", New: "v2"},
+		{Action: deployHistoryActionUpdate, SpecSha256: "ccc", Previous: "v2", New: "v3"},
+	}
+
+	target, err := rollbackTarget(entries, 0)
+	assert.Assert(t, err == nil)
+	assert.EqualString(t, target.compose, "v2")
+	assert.EqualString(t, target.specSha256, "bbb")
+
+	target, err = rollbackTarget(entries, 1)
+	assert.Assert(t, err == nil)
+	assert.EqualString(t, target.compose, "v1")
+	assert.EqualString(t, target.specSha256, "aaa")
+
+	rolledBack := append(entries, deployHistoryEntry{
+		Action: deployHistoryActionRollback, SpecSha256: "bbb", Previous: "v3", New: "v2"})
+
+	_, err = rollbackTarget(rolledBack, 0)
+	assert.EqualString(t, err.Error(), "latest deploy was a rollback; use --to to choose the entry to roll back to")
+
+	target, err = rollbackTarget(rolledBack, 1)
+	assert.Assert(t, err == nil)
+	assert.EqualString(t, target.compose, "v1")
+
+	_, err = rollbackTarget(entries, 4)
+	assert.EqualString(t, err.Error(), "--to must be in range 1-3")
+
+	_, err = rollbackTarget(entries[:1], 0)
+	assert.EqualString(t, err.Error(), "latest deploy created the stack; nothing to roll back to")
+
+	_, err = rollbackTarget(nil, 0)
+	assert.EqualString(t, err.Error(), "no deploy history")
+}
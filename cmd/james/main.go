@@ -86,6 +86,9 @@ func main() {
 		iacEntry(),
 		dnsEntry(),
 		specToComposeEntry(),
+		specToK8sEntry(),
+		composeToSpecEntry(),
+		specEntry(),
 		domainsEntry(),
 		stackEntry(),
 	}
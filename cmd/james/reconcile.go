@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/function61/gokit/backoff"
+	"github.com/function61/gokit/httputils"
+	"github.com/function61/gokit/logex"
+	"github.com/function61/gokit/osutil"
+	"github.com/function61/gokit/taskrunner"
+	"github.com/spf13/cobra"
+)
+
+// GitOps: keeps pulling a git checkout and applies its specs to the cluster without asking for
+// approval, so merges to main deploy on their own. run it from the cluster's directory
+// (like the other commands), pointing to the stacks directory inside the checkout.
+
+const reconcileMaxBackoff = 15 * time.Minute
+
+// exposed as JSON on the HTTP status endpoint
+type reconcileStatus struct {
+	Commit              string     `json:"commit"`
+	LastAttempt         *time.Time `json:"last_attempt"`
+	LastSuccess         *time.Time `json:"last_success"`
+	LastError           string     `json:"last_error"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastChanges         []string   `json:"last_changes"` // "update hellohttp"
+}
+
+type reconciler struct {
+	dir    string
+	prune  bool // delete stacks whose spec was removed
+	logl   *logex.Leveled
+	status reconcileStatus
+	mu     sync.Mutex
+}
+
+func stackReconcile(dir string, interval time.Duration, prune bool, statusAddr string) error {
+	logger := logex.StandardLogger()
+
+	r := &reconciler{
+		dir:   dir,
+		prune: prune,
+		logl:  logex.Levels(logger),
+	}
+
+	tasks := taskrunner.New(osutil.CancelOnInterruptOrTerminate(logger), logger)
+
+	if statusAddr != "" {
+		srv := &http.Server{
+			Addr:    statusAddr,
+			Handler: r.statusHandler(),
+		}
+
+		tasks.Start("status "+statusAddr, func(_ context.Context) error {
+			return httputils.RemoveGracefulServerClosedError(srv.ListenAndServe())
+		})
+
+		tasks.Start("statusshutdowner", httputils.ServerShutdownTask(srv))
+	}
+
+	tasks.Start("reconciler", func(ctx context.Context) error {
+		return r.run(ctx, interval)
+	})
+
+	return tasks.Wait()
+}
+
+func (r *reconciler) run(ctx context.Context, interval time.Duration) error {
+	backoffDuration := backoff.ExponentialWithCappedMax(interval, reconcileMaxBackoff)
+
+	for {
+		wait := interval
+
+		if err := r.reconcileOnce(ctx); err != nil {
+			r.logl.Error.Println(err.Error())
+
+			// backing off from a bad spec doesn't help, it stays bad until the next commit. the
+			// specs without errors have been applied anyway
+			if !errors.As(err, &checkoutError{}) {
+				wait += backoffDuration()
+			}
+		} else {
+			backoffDuration = backoff.ExponentialWithCappedMax(interval, reconcileMaxBackoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (r *reconciler) reconcileOnce(ctx context.Context) error {
+	now := time.Now()
+
+	changes, commit, err := r.pullAndApply(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.LastAttempt = &now
+
+	if commit != "" {
+		r.status.Commit = commit
+	}
+
+	if len(changes) > 0 {
+		r.status.LastChanges = changes
+	}
+
+	if err != nil {
+		r.status.LastError = err.Error()
+		r.status.ConsecutiveFailures++
+		return err
+	}
+
+	r.status.LastSuccess = &now
+	r.status.LastError = ""
+	r.status.ConsecutiveFailures = 0
+
+	return nil
+}
+
+// returns applied changes and the commit they were applied from. specs with errors are skipped
+// (and returned as error) after applying the rest
+func (r *reconciler) pullAndApply(ctx context.Context) ([]string, string, error) {
+	if err := gitInDir(ctx, r.dir, "pull", "--ff-only", "--quiet"); err != nil {
+		return nil, "", err
+	}
+
+	commit, err := gitOutputInDir(ctx, r.dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// re-read, as the pull could have changed it
+	jctx, err := readJamesfile()
+	if err != nil {
+		return nil, commit, checkoutError{err}
+	}
+
+	portainer, err := makePortainerClient2(ctx, *jctx)
+	if err != nil {
+		return nil, commit, err
+	}
+
+	plan, err := makeStackPlan(ctx, r.dir, jctx, portainer, false)
+	if err != nil {
+		return nil, commit, err
+	}
+
+	applied := stackPlan{}
+	changes := []string{}
+
+	for _, change := range plan.changes {
+		switch {
+		case change.kind == stackChangeUnchanged:
+			continue
+		case change.kind == stackChangeError: // returned (and thus logged) as error after applying the rest
+			continue
+		case change.kind == stackChangeDelete && !r.prune:
+			r.logl.Info.Printf("spec of %s removed, not deleting (no --prune)", change.name)
+			continue
+		}
+
+		r.logl.Info.Printf("%s %s (%s) @ %s", change.kind, change.name, change.jamesRef, commit)
+
+		applied.changes = append(applied.changes, change)
+		changes = append(changes, fmt.Sprintf("%s %s", change.kind, change.name))
+	}
+
+	if len(applied.changes) == 0 {
+		return nil, commit, plan.specErrors()
+	}
+
+	if err := applyStackPlan(ctx, applied, portainer, r.logl.Info); err != nil {
+		return nil, commit, err
+	}
+
+	return changes, commit, plan.specErrors()
+}
+
+func (r *reconciler) statusHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		httputils.NoCacheHeaders(w)
+		w.Header().Set("Content-Type", "application/json")
+
+		// lets monitoring alert on the status code alone
+		if r.status.ConsecutiveFailures > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		if err := json.NewEncoder(w).Encode(r.status); err != nil {
+			r.logl.Error.Println(err.Error())
+		}
+	})
+
+	return mux
+}
+
+func gitInDir(ctx context.Context, dir string, args ...string) error {
+	_, err := gitOutputInDir(ctx, dir, args...)
+	return err
+}
+
+func gitOutputInDir(ctx context.Context, dir string, args ...string) (string, error) {
+	output, err := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, output)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+func stackReconcileEntry() *cobra.Command {
+	watch := false
+	interval := 1 * time.Minute
+	prune := false
+	statusAddr := "127.0.0.1:8080"
+
+	cmd := &cobra.Command{
+		Use:   "reconcile <stacks dir in git checkout>",
+		Short: "Pulls git checkout and applies its specs without approval (GitOps)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			osutil.ExitIfError(func() error {
+				if !watch {
+					return errors.New("only --watch mode is supported (for one-off runs use $ james stack apply)")
+				}
+
+				return stackReconcile(args[0], interval, prune, statusAddr)
+			}())
+		},
+	}
+
+	cmd.Flags().BoolVarP(&watch, "watch", "", watch, "Keep running, reconciling at interval")
+	cmd.Flags().DurationVarP(&interval, "interval", "", interval, "How often to pull and reconcile")
+	cmd.Flags().BoolVarP(&prune, "prune", "", prune, "Delete stacks whose spec was removed")
+	cmd.Flags().StringVarP(&statusAddr, "status-addr", "", statusAddr, "Address for HTTP status endpoint (GET /status). empty = disabled")
+
+	return cmd
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/function61/gokit/osutil"
+	"github.com/function61/james/pkg/servicespec"
+	"github.com/spf13/cobra"
+)
+
+func specFmtEntry() *cobra.Command {
+	check := false
+
+	cmd := &cobra.Command{
+		Use:   "fmt <path> [<path> ...]",
+		Short: "Rewrite specs into canonical form (sorted services, env etc.)",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			osutil.ExitIfError(specFmt(args, check))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&check, "check", "", check, "Don't write, but fail if any spec is not in canonical form (for CI)")
+
+	return cmd
+}
+
+func specFmt(paths []string, check bool) error {
+	unformatted := 0
+
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		formatted, err := servicespec.FormatSpec(content, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if bytes.Equal(content, formatted) {
+			continue
+		}
+
+		unformatted++
+
+		if check {
+			fmt.Println(path)
+			continue
+		}
+
+		if err := ioutil.WriteFile(path, formatted, 0644); err != nil {
+			return err
+		}
+	}
+
+	if check && unformatted > 0 {
+		return fmt.Errorf("%d spec(s) not in canonical form. run $ james spec fmt", unformatted)
+	}
+
+	return nil
+}
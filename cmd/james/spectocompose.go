@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/function61/gokit/osutil"
@@ -9,15 +10,34 @@ import (
 )
 
 func specToComposeEntry() *cobra.Command {
-	return &cobra.Command{
+	pinDigests := false
+
+	cmd := &cobra.Command{
 		Use:   "spec-to-compose <path>",
 		Short: "Spec (HCL) to YAML",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			yamlContent, err := servicespec.SpecToComposeByPath(args[0])
+			jctx, err := readJamesfile()
+			osutil.ExitIfError(err)
+
+			jamesRef, err := makeJamesRef(jctx, args[0])
+			osutil.ExitIfError(err)
+
+			clusterCtx := specClusterContext(jctx, jamesRef)
+
+			osutil.ExitIfError(enforcePolicy(args[0], clusterCtx))
+
+			yamlContent, _, err := servicespec.SpecToComposeByPath(
+				args[0],
+				clusterCtx,
+				makeImageDigestResolver(context.TODO(), jctx, pinDigests))
 			osutil.ExitIfError(err)
 
 			fmt.Printf("%s\n", yamlContent)
 		},
 	}
+
+	cmd.Flags().BoolVarP(&pinDigests, "pin-digests", "", pinDigests, "Resolve image tags to digests via the registry")
+
+	return cmd
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/function61/gokit/osutil"
+	"github.com/function61/james/pkg/servicespec"
+	"github.com/spf13/cobra"
+)
+
+func specToK8sEntry() *cobra.Command {
+	return &cobra.Command{
+		Use:   "spec-to-k8s <path>",
+		Short: "Spec (HCL) to Kubernetes manifests (YAML)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			jctx, err := readJamesfile()
+			osutil.ExitIfError(err)
+
+			jamesRef, err := makeJamesRef(jctx, args[0])
+			osutil.ExitIfError(err)
+
+			manifests, err := servicespec.SpecToK8sByPath(
+				args[0],
+				specClusterContext(jctx, jamesRef))
+			osutil.ExitIfError(err)
+
+			fmt.Print(manifests)
+		},
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/function61/gokit/osutil"
+	"github.com/function61/james/pkg/servicespec"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/spf13/cobra"
+)
+
+func specEntry() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "spec",
+		Short: "Spec (HCL) related commands",
+	}
+
+	cmd.AddCommand(specValidateEntry())
+	cmd.AddCommand(specFmtEntry())
+
+	return cmd
+}
+
+func specValidateEntry() *cobra.Command {
+	asJson := false
+
+	cmd := &cobra.Command{
+		Use:   "validate <path> [<path> ...]",
+		Short: "Validate specs, reporting all problems at once",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			osutil.ExitIfError(specValidate(args, asJson))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&asJson, "json", "", asJson, "Output diagnostics as JSON (for pre-commit hooks etc.)")
+
+	return cmd
+}
+
+// machine-readable form of hcl.Diagnostic
+type validationDiagnostic struct {
+	Severity string           `json:"severity"` // "error" | "warning"
+	Summary  string           `json:"summary"`
+	Detail   string           `json:"detail,omitempty"`
+	Range    *validationRange `json:"range,omitempty"`
+}
+
+type validationRange struct {
+	Filename string        `json:"filename"`
+	Start    validationPos `json:"start"`
+	End      validationPos `json:"end"`
+}
+
+type validationPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func specValidate(paths []string, asJson bool) error {
+	jctx, err := readJamesfile()
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*hcl.File{}
+	allDiags := hcl.Diagnostics{}
+
+	for _, path := range paths {
+		jamesRef, err := makeJamesRef(jctx, path)
+		if err != nil {
+			return err
+		}
+
+		file, diags := servicespec.ValidateSpecByPath(
+			path,
+			specClusterContext(jctx, jamesRef))
+		if file != nil {
+			files[path] = file
+		}
+
+		allDiags = append(allDiags, diags...)
+	}
+
+	if asJson {
+		if err := writeDiagnosticsJson(allDiags); err != nil {
+			return err
+		}
+	} else {
+		diagWriter := hcl.NewDiagnosticTextWriter(os.Stderr, files, 100, false)
+		if err := diagWriter.WriteDiagnostics(allDiags); err != nil {
+			return err
+		}
+	}
+
+	return validationResult(allDiags)
+}
+
+// warnings alone don't fail validation, but are counted so the summary matches what was printed
+func validationResult(diags hcl.Diagnostics) error {
+	if !diags.HasErrors() {
+		return nil
+	}
+
+	errorCount := 0
+	for _, diag := range diags {
+		if diag.Severity == hcl.DiagError {
+			errorCount++
+		}
+	}
+
+	return fmt.Errorf("%d error(s), %d warning(s) found", errorCount, len(diags)-errorCount)
+}
+
+func writeDiagnosticsJson(diags hcl.Diagnostics) error {
+	items := []validationDiagnostic{}
+
+	for _, diag := range diags {
+		item := validationDiagnostic{
+			Summary: diag.Summary,
+			Detail:  diag.Detail,
+		}
+
+		switch diag.Severity {
+		case hcl.DiagError:
+			item.Severity = "error"
+		case hcl.DiagWarning:
+			item.Severity = "warning"
+		default:
+			return errors.New("unknown diagnostic severity")
+		}
+
+		if diag.Subject != nil {
+			item.Range = &validationRange{
+				Filename: diag.Subject.Filename,
+				Start:    validationPos{Line: diag.Subject.Start.Line, Column: diag.Subject.Start.Column},
+				End:      validationPos{Line: diag.Subject.End.Line, Column: diag.Subject.End.Column},
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	jsonEncoder := json.NewEncoder(os.Stdout)
+	jsonEncoder.SetIndent("", "  ")
+	return jsonEncoder.Encode(items)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/assert"
+	"github.com/hashicorp/hcl/v2"
+)
+
+func TestValidationResult(t *testing.T) {
+	warning := &hcl.Diagnostic{Severity: hcl.DiagWarning, Summary: "deprecated"}
+	problem := &hcl.Diagnostic{Severity: hcl.DiagError, Summary: "invalid"}
+
+	assert.Assert(t, validationResult(hcl.Diagnostics{}) == nil)
+	assert.Assert(t, validationResult(hcl.Diagnostics{warning, warning}) == nil)
+	assert.EqualString(t, validationResult(hcl.Diagnostics{warning, problem, warning}).Error(), "1 error(s), 2 warning(s) found")
+}
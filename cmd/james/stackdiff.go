@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/function61/james/pkg/servicespec"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[36m"
+	ansiReset = "\x1b[0m"
+)
+
+// lines of unchanged context around changes in unified diff
+const unifiedDiffContext = 3
+
+// prints structural (service by service, field by field) diff if possible, otherwise unified text diff
+func printStackDiff(previous string, updated string) {
+	fmt.Print(stackDiff(previous, updated, true))
+}
+
+func stackDiff(previous string, updated string, colors bool) string {
+	// for new and deleted stacks the whole content is relevant
+	if previous != "" && updated != "" {
+		changes, err := servicespec.DiffCompose(previous, updated)
+
+		// no structural changes but text differs => something we don't parse changed
+		if err == nil && (len(changes) > 0 || previous == updated) {
+			output := &strings.Builder{}
+			for _, change := range changes {
+				output.WriteString(change + "\n")
+			}
+
+			return output.String()
+		}
+	}
+
+	return unifiedDiff(previous, updated, colors)
+}
+
+type diffLine struct {
+	op   diffmatchpatch.Operation
+	text string
+}
+
+func unifiedDiff(previous string, updated string, colors bool) string {
+	dmp := diffmatchpatch.New()
+
+	previousChars, updatedChars, lineArray := dmp.DiffLinesToChars(previous, updated)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(previousChars, updatedChars, false), lineArray)
+
+	lines := []diffLine{}
+	for _, diff := range diffs {
+		for _, line := range strings.SplitAfter(diff.Text, "\n") {
+			if line != "" {
+				lines = append(lines, diffLine{diff.Type, strings.TrimSuffix(line, "\n")})
+			}
+		}
+	}
+
+	colorize := func(color string, text string) string {
+		if !colors {
+			return text
+		}
+
+		return color + text + ansiReset
+	}
+
+	output := &strings.Builder{}
+
+	// line numbers (1-based) of the hunk's first line in previous and updated
+	previousLine, updatedLine := 1, 1
+
+	for i := 0; i < len(lines); {
+		if lines[i].op == diffmatchpatch.DiffEqual {
+			previousLine++
+			updatedLine++
+			i++
+			continue
+		}
+
+		// hunk = changes that are at most 2*context apart, along with context
+		start := i - unifiedDiffContext
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for end < len(lines) {
+			if lines[end].op != diffmatchpatch.DiffEqual {
+				end++
+				continue
+			}
+
+			equalRun := 0
+			for end+equalRun < len(lines) && lines[end+equalRun].op == diffmatchpatch.DiffEqual {
+				equalRun++
+			}
+
+			if end+equalRun == len(lines) || equalRun > 2*unifiedDiffContext {
+				if equalRun > unifiedDiffContext {
+					equalRun = unifiedDiffContext
+				}
+
+				end += equalRun
+				break
+			}
+
+			end += equalRun
+		}
+
+		hunkPreviousStart := previousLine - (i - start)
+		hunkUpdatedStart := updatedLine - (i - start)
+		previousCount, updatedCount := 0, 0
+
+		hunk := &strings.Builder{}
+		for _, line := range lines[start:end] {
+			switch line.op {
+			case diffmatchpatch.DiffEqual:
+				hunk.WriteString(" " + line.text + "\n")
+				previousCount++
+				updatedCount++
+			case diffmatchpatch.DiffDelete:
+				hunk.WriteString(colorize(ansiRed, "-"+line.text) + "\n")
+				previousCount++
+			case diffmatchpatch.DiffInsert:
+				hunk.WriteString(colorize(ansiGreen, "+"+line.text) + "\n")
+				updatedCount++
+			}
+		}
+
+		// empty range is denoted by the line before it
+		if previousCount == 0 {
+			hunkPreviousStart--
+		}
+		if updatedCount == 0 {
+			hunkUpdatedStart--
+		}
+
+		output.WriteString(colorize(ansiCyan, fmt.Sprintf(
+			"@@ -%d,%d +%d,%d @@",
+			hunkPreviousStart,
+			previousCount,
+			hunkUpdatedStart,
+			updatedCount)) + "\n")
+		output.WriteString(hunk.String())
+
+		for _, line := range lines[i:end] {
+			switch line.op {
+			case diffmatchpatch.DiffEqual:
+				previousLine++
+				updatedLine++
+			case diffmatchpatch.DiffDelete:
+				previousLine++
+			case diffmatchpatch.DiffInsert:
+				updatedLine++
+			}
+		}
+
+		i = end
+	}
+
+	return output.String()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestStackDiffStructural(t *testing.T) {
+	previous := "version: \"3.5\"\nservices:\n  web:\n    image: foo:1.2\n    environment:\n      A: a\n"
+	updated := "version: \"3.5\"\nservices:\n  web:\n    environment:\n      A: a\n      X: x\n    image: foo:1.3\n"
+
+	assert.EqualString(t, stackDiff(previous, updated, false), `service web: env X added: x
+service web: image foo:1.2 -> foo:1.3
+`)
+}
+
+func TestStackDiffFallsBackToUnified(t *testing.T) {
+	lines := func(from int, to int, changed map[int]string) string {
+		result := []string{}
+		for i := from; i <= to; i++ {
+			if text, found := changed[i]; found {
+				result = append(result, text)
+			} else {
+				result = append(result, "# line "+string(rune('a'+i)))
+			}
+		}
+
+		return strings.Join(result, "\n") + "\n"
+	}
+
+	// only comments differ, which compose parsing doesn't see
+	previous := lines(0, 15, nil)
+	updated := lines(0, 15, map[int]string{1: "# changed 1", 13: "# changed 13"})
+
+	assert.EqualString(t, stackDiff(previous, updated, false), `@@ -1,5 +1,5 @@
+ # line a
+-# line b
++# changed 1
+ # line c
+ # line d
+ # line e
+@@ -11,6 +11,6 @@
+ # line k
+ # line l
+ # line m
+-# line n
++# changed 13
+ # line o
+ # line p
+`)
+
+	// new stack
+	assert.EqualString(t, stackDiff("", "version: \"3.5\"\n", false), `@@ -0,0 +1,1 @@
++version: "3.5"
+`)
+}
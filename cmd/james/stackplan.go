@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/function61/gokit/osutil"
+	"github.com/function61/james/pkg/jamestypes"
+	"github.com/function61/james/pkg/portainerclient"
+	"github.com/function61/james/pkg/servicespec"
+	"github.com/spf13/cobra"
+)
+
+// Terraform-style reconciliation: the spec files of a directory are the desired state of the
+// cluster's stacks. stacks whose spec file was removed from the directory get deleted.
+
+type stackChangeKind string
+
+const (
+	stackChangeCreate    stackChangeKind = "create"
+	stackChangeUpdate    stackChangeKind = "update"
+	stackChangeDelete    stackChangeKind = "delete"
+	stackChangeUnchanged stackChangeKind = "unchanged"
+	stackChangeError     stackChangeKind = "error" // spec could not be planned, its stack is left as-is
+)
+
+type stackChange struct {
+	kind        stackChangeKind
+	name        string // stack name
+	jamesRef    string
+	stackId     int    // for update and delete
+	previous    string // deployed compose ("" for create)
+	updated     string // spec's compose ("" for delete)
+	specSha256  string
+	fileObjects []servicespec.FileObject
+	err         error // for error
+}
+
+type stackPlan struct {
+	changes []stackChange
+}
+
+func (p stackPlan) count(kind stackChangeKind) int {
+	count := 0
+	for _, change := range p.changes {
+		if change.kind == kind {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (p stackPlan) hasChanges() bool {
+	return p.count(stackChangeCreate)+p.count(stackChangeUpdate)+p.count(stackChangeDelete) > 0
+}
+
+// all problems with the plan's specs as one error, or nil if there were none
+func (p stackPlan) specErrors() error {
+	errs := []string{}
+	for _, change := range p.changes {
+		if change.kind == stackChangeError {
+			errs = append(errs, change.err.Error())
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return checkoutError{fmt.Errorf("%d spec(s) with errors: %s", len(errs), strings.Join(errs, "; "))}
+}
+
+// problem with the specs or their directory, as opposed to Portainer or the network. retrying
+// won't fix it until the files change
+type checkoutError struct {
+	error
+}
+
+func stackPlanOrApply(dir string, apply bool, pinDigests bool) error {
+	ctx := context.TODO() // take from caller
+
+	jctx, err := readJamesfile()
+	if err != nil {
+		return err
+	}
+
+	portainer, err := makePortainerClient2(ctx, *jctx)
+	if err != nil {
+		return err
+	}
+
+	plan, err := makeStackPlan(ctx, dir, jctx, portainer, pinDigests)
+	if err != nil {
+		return err
+	}
+
+	printStackPlan(*plan)
+
+	if err := plan.specErrors(); err != nil {
+		return err
+	}
+
+	if !plan.hasChanges() || !apply {
+		return nil
+	}
+
+	if err := askForAck("apply"); err != nil {
+		return err
+	}
+
+	if err := applyStackPlan(ctx, *plan, portainer, log.New(os.Stdout, "", 0)); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ p.s. " + randomJurassicParkQuote())
+
+	return nil
+}
+
+func makeStackPlan(
+	ctx context.Context,
+	dir string,
+	jctx *jamestypes.JamesfileCtx,
+	portainer *portainerclient.Client,
+	pinDigests bool,
+) (*stackPlan, error) {
+	clusterDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	specs, err := findSpecs(dir, clusterDir, jctx.ClusterID)
+	if err != nil {
+		return nil, checkoutError{err}
+	}
+
+	planDir, err := canonicalSpecPath(clusterDir, dir)
+	if err != nil {
+		return nil, checkoutError{err}
+	}
+
+	stacks, err := portainer.ListStacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &stackPlan{}
+
+	desiredRefs := map[string]bool{}
+
+	for _, spec := range specs {
+		desiredRefs[spec.jamesRef] = true
+
+		change, err := planSpec(ctx, spec, jctx, pinDigests)
+		if err != nil {
+			// one broken spec should not keep the rest from being planned (like in $ james stack ls)
+			change = stackChange{
+				kind:     stackChangeError,
+				name:     spec.stackName,
+				jamesRef: spec.jamesRef,
+				err:      err,
+			}
+		}
+
+		if stack := findPortainerStackByRef(spec.jamesRef, jctx.Cluster.PortainerEndpointId, stacks); stack != nil {
+			change.name = stack.Name
+			change.stackId = stack.Id
+
+			if change.kind == stackChangeError {
+				plan.changes = append(plan.changes, change)
+				continue
+			}
+
+			previous, err := portainer.StackFile(ctx, strconv.Itoa(stack.Id))
+			if err != nil {
+				return nil, err
+			}
+
+			change.previous = previous
+
+			// digests pinned by an earlier --pin-digests deploy are not a reason to re-deploy (which
+			// would un-pin them), the same way as they're not drift for $ james stack ls
+			if previous == change.updated || (!pinDigests && composeMatchesDeployed(change.updated, previous)) {
+				change.kind = stackChangeUnchanged
+			} else {
+				change.kind = stackChangeUpdate
+			}
+		}
+
+		plan.changes = append(plan.changes, change)
+	}
+
+	for _, stack := range orphanedStacks(stacks, jctx, planDir, desiredRefs) {
+		previous, err := portainer.StackFile(ctx, strconv.Itoa(stack.Id))
+		if err != nil {
+			return nil, err
+		}
+
+		plan.changes = append(plan.changes, stackChange{
+			kind:     stackChangeDelete,
+			name:     stack.Name,
+			jamesRef: stackJamesRef(stack),
+			stackId:  stack.Id,
+			previous: previous,
+		})
+	}
+
+	plan.changes = movedSpecsAsUpdates(plan.changes)
+
+	sort.SliceStable(plan.changes, func(i, j int) bool {
+		return plan.changes[i].name < plan.changes[j].name
+	})
+
+	return plan, nil
+}
+
+// spec as if its stack didn't exist yet
+func planSpec(
+	ctx context.Context,
+	spec specInDir,
+	jctx *jamestypes.JamesfileCtx,
+	pinDigests bool,
+) (stackChange, error) {
+	clusterCtx := specClusterContext(jctx, spec.jamesRef)
+
+	if err := enforcePolicy(spec.path, clusterCtx); err != nil {
+		return stackChange{}, err
+	}
+
+	updated, fileObjects, err := servicespec.SpecToComposeByPath(
+		spec.path,
+		clusterCtx,
+		makeImageDigestResolver(ctx, jctx, pinDigests))
+	if err != nil {
+		return stackChange{}, fmt.Errorf("%s: %w", spec.path, err)
+	}
+
+	specSha256, err := specFileSha256(spec.path)
+	if err != nil {
+		return stackChange{}, err
+	}
+
+	return stackChange{
+		kind:        stackChangeCreate,
+		name:        spec.stackName,
+		jamesRef:    spec.jamesRef,
+		updated:     updated,
+		specSha256:  specSha256,
+		fileObjects: fileObjects,
+	}, nil
+}
+
+// spec that was moved or renamed within dir shows up as its new ref being created and old ref
+// deleted. stack names are unique, so that'd fail. update the existing stack (and its ref) instead.
+// moved spec that has errors must not get its stack deleted either
+func movedSpecsAsUpdates(changes []stackChange) []stackChange {
+	deletesByName := map[string]stackChange{}
+	createdNames := map[string]bool{}
+
+	for _, change := range changes {
+		switch change.kind {
+		case stackChangeDelete:
+			deletesByName[change.name] = change
+		case stackChangeCreate, stackChangeError:
+			createdNames[change.name] = true
+		}
+	}
+
+	result := []stackChange{}
+
+	for _, change := range changes {
+		deleted, moved := deletesByName[change.name]
+		moved = moved && createdNames[change.name]
+
+		switch {
+		case moved && change.kind == stackChangeDelete:
+			continue
+		case moved && change.kind == stackChangeCreate:
+			change.kind = stackChangeUpdate
+			change.stackId = deleted.stackId
+			change.previous = deleted.previous
+		case moved && change.kind == stackChangeError:
+			change.stackId = deleted.stackId
+		}
+
+		result = append(result, change)
+	}
+
+	return result
+}
+
+func printStackPlan(plan stackPlan) {
+	symbols := map[stackChangeKind]string{
+		stackChangeCreate: "+",
+		stackChangeUpdate: "~",
+		stackChangeDelete: "-",
+		stackChangeError:  "!",
+	}
+
+	for _, change := range plan.changes {
+		if change.kind == stackChangeUnchanged {
+			continue
+		}
+
+		fmt.Printf("%s %s %s (%s)\n", symbols[change.kind], change.kind, change.name, change.jamesRef)
+
+		if change.kind == stackChangeError {
+			fmt.Println(change.err.Error())
+			continue
+		}
+
+		printStackDiff(change.previous, change.updated)
+	}
+
+	fmt.Printf(
+		"Plan: %d to create, %d to update, %d to delete, %d unchanged, %d with errors\n",
+		plan.count(stackChangeCreate),
+		plan.count(stackChangeUpdate),
+		plan.count(stackChangeDelete),
+		plan.count(stackChangeUnchanged),
+		plan.count(stackChangeError))
+}
+
+// file objects first, so stacks referencing them can be deployed
+func applyStackPlan(
+	ctx context.Context,
+	plan stackPlan,
+	portainer *portainerclient.Client,
+	logl *log.Logger,
+) error {
+	fileObjects := []servicespec.FileObject{}
+	for _, change := range plan.changes {
+		if change.kind == stackChangeCreate || change.kind == stackChangeUpdate {
+			fileObjects = append(fileObjects, change.fileObjects...)
+		}
+	}
+
+	if err := ensureFileObjectsExist(ctx, portainer, fileObjects, false); err != nil {
+		return err
+	}
+
+	// deletes first, so a deleted stack's name is free to be reused
+	ordered := []stackChange{}
+	for _, change := range plan.changes {
+		if change.kind == stackChangeDelete {
+			ordered = append(ordered, change)
+		}
+	}
+	for _, change := range plan.changes {
+		if change.kind != stackChangeDelete {
+			ordered = append(ordered, change)
+		}
+	}
+
+	for _, change := range ordered {
+		var err error
+
+		switch change.kind {
+		case stackChangeCreate:
+			err = portainer.CreateStack(ctx, change.name, change.jamesRef, change.updated)
+		case stackChangeUpdate:
+			err = portainer.UpdateStack(ctx, strconv.Itoa(change.stackId), change.jamesRef, change.updated)
+		case stackChangeDelete:
+			err = portainer.DeleteStack(ctx, change.stackId)
+		case stackChangeUnchanged, stackChangeError:
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", change.kind, change.name, err)
+		}
+
+		switch change.kind {
+		case stackChangeCreate:
+			recordDeploy(deployHistoryActionCreate, change.jamesRef, change.specSha256, "", change.updated)
+		case stackChangeUpdate:
+			recordDeploy(deployHistoryActionUpdate, change.jamesRef, change.specSha256, change.previous, change.updated)
+		}
+
+		logl.Printf("%s %s: done", change.kind, change.name)
+	}
+
+	return nil
+}
+
+type specInDir struct {
+	path      string
+	jamesRef  string
+	stackName string
+}
+
+// relative dir is relative to cluster dir. stack names come from file names, so they must be unique
+func findSpecs(dir string, clusterDir string, clusterID string) ([]specInDir, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(clusterDir, dir)
+	}
+
+	specPaths, err := findSpecFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := []specInDir{}
+	pathsByStackName := map[string]string{}
+
+	for _, specPath := range specPaths {
+		jamesRef, err := jamesRefRelativeTo(clusterDir, clusterID, specPath)
+		if err != nil {
+			return nil, err
+		}
+
+		stackName := stackNameFromSpecPath(specPath)
+
+		if otherPath, duplicate := pathsByStackName[stackName]; duplicate {
+			return nil, fmt.Errorf("%s and %s would both be stack %s", otherPath, specPath, stackName)
+		}
+		pathsByStackName[stackName] = specPath
+
+		specs = append(specs, specInDir{
+			path:      specPath,
+			jamesRef:  jamesRef,
+			stackName: stackName,
+		})
+	}
+
+	return specs, nil
+}
+
+// .hcl files of dir (recursively)
+func findSpecFiles(dir string) ([]string, error) {
+	specPaths := []string{}
+
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && filepath.Ext(path) == ".hcl" {
+			specPaths = append(specPaths, path)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return specPaths, nil
+}
+
+// stacks that we deployed from a spec of dir (canonical path), but whose spec no longer exists
+func orphanedStacks(
+	stacks []portainerclient.Stack,
+	jctx *jamestypes.JamesfileCtx,
+	dir string,
+	desiredRefs map[string]bool,
+) []portainerclient.Stack {
+	orphaned := []portainerclient.Stack{}
+
+	for _, stack := range stacks {
+		if strconv.Itoa(stack.EndpointID) != jctx.Cluster.PortainerEndpointId {
+			continue
+		}
+
+		jamesRef := stackJamesRef(stack)
+		if jamesRef == "" || desiredRefs[jamesRef] { // unmanaged or still wanted
+			continue
+		}
+
+		clusterPrefix := jctx.ClusterID + ":"
+		if !strings.HasPrefix(jamesRef, clusterPrefix) {
+			continue
+		}
+
+		// other directories' stacks are not ours to delete
+		if !isInDir(strings.TrimPrefix(jamesRef, clusterPrefix), dir) {
+			continue
+		}
+
+		orphaned = append(orphaned, stack)
+	}
+
+	return orphaned
+}
+
+func isInDir(path string, dir string) bool {
+	dir = filepath.Clean(dir)
+	if dir == "." {
+		return !strings.HasPrefix(filepath.Clean(path), "..")
+	}
+
+	return strings.HasPrefix(filepath.Clean(path), dir+string(filepath.Separator))
+}
+
+// "stacks/hellohttp.hcl" => "hellohttp"
+func stackNameFromSpecPath(specPath string) string {
+	return strings.TrimSuffix(filepath.Base(specPath), filepath.Ext(specPath))
+}
+
+func stackPlanEntry() *cobra.Command {
+	pinDigests := false
+
+	cmd := &cobra.Command{
+		Use:   "plan <dir>",
+		Short: "Shows which stacks would be created, updated or deleted to match the specs of dir",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			osutil.ExitIfError(stackPlanOrApply(args[0], false, pinDigests))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&pinDigests, "pin-digests", "", pinDigests, "Resolve image tags to digests, so re-pushed tags show up in the diff")
+
+	return cmd
+}
+
+func stackApplyEntry() *cobra.Command {
+	pinDigests := false
+
+	cmd := &cobra.Command{
+		Use:   "apply <dir>",
+		Short: "Creates, updates and deletes stacks to match the specs of dir (after approval)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			osutil.ExitIfError(stackPlanOrApply(args[0], true, pinDigests))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&pinDigests, "pin-digests", "", pinDigests, "Resolve image tags to digests, so re-pushed tags show up in the diff")
+
+	return cmd
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestFindSpecsAbsoluteAndRelativeDirGiveSameRefs(t *testing.T) {
+	clusterDir := makeSpecsDir(t, "stacks/hellohttp.hcl", "stacks/db/postgres.hcl")
+	defer os.RemoveAll(clusterDir)
+
+	refs := func(dir string) string {
+		specs, err := findSpecs(dir, clusterDir, "prod5")
+		assert.Assert(t, err == nil)
+
+		result := []string{}
+		for _, spec := range specs {
+			result = append(result, spec.stackName+"="+spec.jamesRef)
+		}
+
+		return strings.Join(result, ",")
+	}
+
+	expected := "postgres=prod5:stacks/db/postgres.hcl,hellohttp=prod5:stacks/hellohttp.hcl"
+
+	assert.EqualString(t, refs("stacks"), expected)
+	assert.EqualString(t, refs("./stacks/"), expected)
+	assert.EqualString(t, refs(filepath.Join(clusterDir, "stacks")), expected)
+}
+
+func TestFindSpecsDuplicateStackName(t *testing.T) {
+	clusterDir := makeSpecsDir(t, "stacks/a/web.hcl", "stacks/b/web.hcl")
+	defer os.RemoveAll(clusterDir)
+
+	_, err := findSpecs("stacks", clusterDir, "prod5")
+	assert.EqualString(t, strings.ReplaceAll(err.Error(), clusterDir+"/", ""), "stacks/a/web.hcl and stacks/b/web.hcl would both be stack web")
+}
+
+// returns the temp dir that the (empty) spec files were created in
+func makeSpecsDir(t *testing.T, specPaths ...string) string {
+	dir, err := ioutil.TempDir("", "james-specs-")
+	assert.Assert(t, err == nil)
+
+	for _, specPath := range specPaths {
+		fullPath := filepath.Join(dir, specPath)
+
+		assert.Assert(t, os.MkdirAll(filepath.Dir(fullPath), 0755) == nil)
+		assert.Assert(t, ioutil.WriteFile(fullPath, []byte{}, 0644) == nil)
+	}
+
+	return dir
+}
+
+func TestMovedSpecsAsUpdates(t *testing.T) {
+	changes := movedSpecsAsUpdates([]stackChange{
+		{kind: stackChangeCreate, name: "hellohttp", jamesRef: "prod5:stacks/web/hellohttp.hcl", updated: "v2"},
+		{kind: stackChangeCreate, name: "new", jamesRef: "prod5:stacks/new.hcl", updated: "v1"},
+		{kind: stackChangeDelete, name: "hellohttp", jamesRef: "prod5:stacks/hellohttp.hcl", stackId: 3, previous: "v1"},
+		{kind: stackChangeDelete, name: "removed", jamesRef: "prod5:stacks/removed.hcl", stackId: 4, previous: "v1"},
+	})
+
+	summary := []string{}
+	for _, change := range changes {
+		summary = append(summary, fmt.Sprintf("%s %s %s #%d", change.kind, change.name, change.jamesRef, change.stackId))
+	}
+
+	assert.EqualString(t, strings.Join(summary, "\n"), `update hellohttp prod5:stacks/web/hellohttp.hcl #3
+create new prod5:stacks/new.hcl #0
+delete removed prod5:stacks/removed.hcl #4`)
+	assert.EqualString(t, changes[0].previous, "v1")
+}
+
+func TestMovedSpecWithErrorKeepsItsStack(t *testing.T) {
+	changes := movedSpecsAsUpdates([]stackChange{
+		{kind: stackChangeError, name: "hellohttp", jamesRef: "prod5:stacks/web/hellohttp.hcl", err: errors.New("bad spec")},
+		{kind: stackChangeDelete, name: "hellohttp", jamesRef: "prod5:stacks/hellohttp.hcl", stackId: 3, previous: "v1"},
+	})
+
+	assert.Assert(t, len(changes) == 1)
+	assert.Assert(t, changes[0].kind == stackChangeError)
+	assert.Assert(t, changes[0].stackId == 3)
+}
+
+func TestStackPlanSpecErrors(t *testing.T) {
+	plan := stackPlan{changes: []stackChange{
+		{kind: stackChangeUnchanged, name: "hellohttp"},
+		{kind: stackChangeError, name: "broken", err: errors.New("stacks/broken.hcl: bad spec")},
+	}}
+
+	assert.Assert(t, !plan.hasChanges())
+
+	err := plan.specErrors()
+	assert.EqualString(t, err.Error(), "1 spec(s) with errors: stacks/broken.hcl: bad spec")
+	// so $ james stack reconcile doesn't back off
+	assert.Assert(t, errors.As(err, &checkoutError{}))
+
+	assert.Assert(t, stackPlan{changes: plan.changes[0:1]}.specErrors() == nil)
+}
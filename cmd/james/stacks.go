@@ -9,18 +9,23 @@ import (
 	"math/big"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/function61/gokit/ezhttp"
 	"github.com/function61/gokit/osutil"
 	"github.com/function61/james/pkg/jamestypes"
 	"github.com/function61/james/pkg/portainerclient"
+	"github.com/function61/james/pkg/registryclient"
 	"github.com/function61/james/pkg/servicespec"
-	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/scylladb/termtables"
 	"github.com/spf13/cobra"
 )
 
-func stackDeploy(path string, dryRun bool, stackName string, retriesLeft int) error {
+func stackDeploy(path string, dryRun bool, stackName string, pinDigests bool, retriesLeft int) error {
 	ctx := context.TODO() // take from caller
 
 	if retriesLeft <= 0 {
@@ -32,18 +37,32 @@ func stackDeploy(path string, dryRun bool, stackName string, retriesLeft int) er
 		return err
 	}
 
-	updated, err := servicespec.SpecToComposeByPath(path)
+	jamesRef, err := makeJamesRef(jctx, path)
 	if err != nil {
 		return err
 	}
 
-	portainer, err := makePortainerClient2(ctx, *jctx)
+	if err := enforcePolicy(path, specClusterContext(jctx, jamesRef)); err != nil {
+		return err
+	}
+
+	updated, fileObjects, err := servicespec.SpecToComposeByPath(
+		path,
+		specClusterContext(jctx, jamesRef),
+		makeImageDigestResolver(ctx, jctx, pinDigests))
 	if err != nil {
 		return err
 	}
 
-	// "prod5:stacks/hellohttp.hcl"
-	jamesRef := jctx.ClusterID + ":" + path
+	specSha256, err := specFileSha256(path)
+	if err != nil {
+		return err
+	}
+
+	portainer, err := makePortainerClient2(ctx, *jctx)
+	if err != nil {
+		return err
+	}
 
 	stacks, err := portainer.ListStacks(ctx)
 	if err != nil {
@@ -51,32 +70,13 @@ func stackDeploy(path string, dryRun bool, stackName string, retriesLeft int) er
 	}
 
 	diffAndAck := func(previous string, needAck bool) error {
-		dmp := diffmatchpatch.New()
-		diffs := dmp.DiffMain(previous, updated, false)
-
-		// or DiffCleanupSemantic?
-		diffs = dmp.DiffCleanupMerge(diffs)
-
-		fmt.Println(dmp.DiffPrettyText(diffs))
+		printStackDiff(previous, updated)
 
 		if !needAck {
 			return nil
 		}
 
-		fmt.Printf("deploy y/n: ")
-
-		line, _, err := bufio.NewReader(os.Stdin).ReadLine()
-		if err != nil {
-			return err
-		}
-
-		if string(line) != "y" {
-			return fmt.Errorf("ack not 'y'; got %s", line)
-		}
-
-		fmt.Println("HOLD ON TO YOUR BUTTS")
-
-		return nil
+		return askForAck("deploy")
 	}
 
 	stack := findPortainerStackByRef(jamesRef, jctx.Cluster.PortainerEndpointId, stacks)
@@ -91,6 +91,10 @@ func stackDeploy(path string, dryRun bool, stackName string, retriesLeft int) er
 			return err
 		}
 
+		if err := ensureFileObjectsExist(ctx, portainer, fileObjects, dryRun); err != nil {
+			return err
+		}
+
 		if dryRun {
 			return nil
 		}
@@ -98,6 +102,8 @@ func stackDeploy(path string, dryRun bool, stackName string, retriesLeft int) er
 		if err := portainer.CreateStack(context.TODO(), stackName, jamesRef, updated); err != nil {
 			return err
 		}
+
+		recordDeploy(deployHistoryActionCreate, jamesRef, specSha256, "", updated)
 	} else { // update existing stack
 		stackId := fmt.Sprintf("%d", stack.Id)
 
@@ -110,6 +116,10 @@ func stackDeploy(path string, dryRun bool, stackName string, retriesLeft int) er
 			return err
 		}
 
+		if err := ensureFileObjectsExist(ctx, portainer, fileObjects, dryRun); err != nil {
+			return err
+		}
+
 		if dryRun {
 			return nil
 		}
@@ -117,6 +127,8 @@ func stackDeploy(path string, dryRun bool, stackName string, retriesLeft int) er
 		if err := portainer.UpdateStack(context.TODO(), stackId, jamesRef, updated); err != nil {
 			return err
 		}
+
+		recordDeploy(deployHistoryActionUpdate, jamesRef, specSha256, previous, updated)
 	}
 
 	fmt.Println("✓ p.s. " + randomJurassicParkQuote())
@@ -124,6 +136,94 @@ func stackDeploy(path string, dryRun bool, stackName string, retriesLeft int) er
 	return nil
 }
 
+// "deploy y/n: "
+func askForAck(action string) error {
+	fmt.Printf("%s y/n: ", action)
+
+	line, _, err := bufio.NewReader(os.Stdin).ReadLine()
+	if err != nil {
+		return err
+	}
+
+	if string(line) != "y" {
+		return fmt.Errorf("ack not 'y'; got %s", line)
+	}
+
+	fmt.Println("HOLD ON TO YOUR BUTTS")
+
+	return nil
+}
+
+// creates secrets and configs that the stack references but which don't yet exist in Swarm.
+// their names are content-addressed, so existing ones never need updating.
+func ensureFileObjectsExist(
+	ctx context.Context,
+	portainer *portainerclient.Client,
+	fileObjects []servicespec.FileObject,
+	dryRun bool,
+) error {
+	if len(fileObjects) == 0 {
+		return nil
+	}
+
+	existingSecrets, err := portainer.ListSecrets(ctx)
+	if err != nil {
+		return err
+	}
+
+	existingConfigs, err := portainer.ListConfigs(ctx)
+	if err != nil {
+		return err
+	}
+
+	exists := func(name string, existing []portainerclient.FileObject) bool {
+		for _, item := range existing {
+			if item.Spec.Name == name {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, fileObject := range fileObjects {
+		switch fileObject.Kind {
+		case servicespec.FileObjectKindSecret:
+			if exists(fileObject.Name, existingSecrets) {
+				continue
+			}
+
+			fmt.Printf("creating secret %s\n", fileObject.Name)
+
+			if dryRun {
+				continue
+			}
+
+			if err := portainer.CreateSecret(ctx, fileObject.Name, fileObject.Content); err != nil {
+				return err
+			}
+		case servicespec.FileObjectKindConfig:
+			if exists(fileObject.Name, existingConfigs) {
+				continue
+			}
+
+			fmt.Printf("creating config %s\n", fileObject.Name)
+
+			if dryRun {
+				continue
+			}
+
+			if err := portainer.CreateConfig(ctx, fileObject.Name, fileObject.Content); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown file object kind: %s", fileObject.Kind)
+		}
+	}
+
+	return nil
+}
+
 func stackRm(path string) error {
 	jctx, err := readJamesfile()
 	if err != nil {
@@ -135,8 +235,10 @@ func stackRm(path string) error {
 		return err
 	}
 
-	// "prod5:stacks/hellohttp.hcl"
-	jamesRef := jctx.ClusterID + ":" + path
+	jamesRef, err := makeJamesRef(jctx, path)
+	if err != nil {
+		return err
+	}
 
 	stacks, err := portainer.ListStacks(context.TODO())
 	if err != nil {
@@ -151,25 +253,186 @@ func stackRm(path string) error {
 	return portainer.DeleteStack(context.TODO(), stack.Id)
 }
 
+// drift status of a deployed stack, compared to its spec
+const (
+	stackStatusInSync         = "in sync"
+	stackStatusDrifted        = "drifted"         // deployed compose differs from spec's current output
+	stackStatusMissingLocally = "missing locally" // JAMES_REF points to spec that doesn't exist
+	stackStatusUnmanaged      = "unmanaged"       // not deployed by us (no JAMES_REF)
+)
+
+func stackLs() error {
+	ctx := context.TODO()
+
+	jctx, err := readJamesfile()
+	if err != nil {
+		return err
+	}
+
+	portainer, err := makePortainerClient2(ctx, *jctx)
+	if err != nil {
+		return err
+	}
+
+	stacks, err := portainer.ListStacks(ctx)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].Name < stacks[j].Name })
+
+	tbl := termtables.CreateTable()
+	tbl.AddHeaders("Stack", "James ref", "Spec exists", "Status")
+
+	for _, stack := range stacks {
+		if strconv.Itoa(stack.EndpointID) != jctx.Cluster.PortainerEndpointId {
+			continue
+		}
+
+		jamesRef := stackJamesRef(stack)
+
+		specPath, specExists := stackSpecPath(jctx, jamesRef)
+
+		// one broken spec should not hide the status of the rest
+		status, err := stackStatus(ctx, portainer, jctx, stack, jamesRef, specPath, specExists)
+		if err != nil {
+			status = "error: " + err.Error()
+		}
+
+		specExistsText := "no"
+		if specExists {
+			specExistsText = "yes"
+		}
+
+		tbl.AddRow(stack.Name, jamesRef, specExistsText, status)
+	}
+
+	fmt.Println(tbl.Render())
+
+	return nil
+}
+
+func stackStatus(
+	ctx context.Context,
+	portainer *portainerclient.Client,
+	jctx *jamestypes.JamesfileCtx,
+	stack portainerclient.Stack,
+	jamesRef string,
+	specPath string,
+	specExists bool,
+) (string, error) {
+	switch {
+	case jamesRef == "":
+		return stackStatusUnmanaged, nil
+	case !specExists:
+		return stackStatusMissingLocally, nil
+	}
+
+	current, _, err := servicespec.SpecToComposeByPath(specPath, specClusterContext(jctx, jamesRef), nil)
+	if err != nil {
+		return "", err
+	}
+
+	deployed, err := portainer.StackFile(ctx, strconv.Itoa(stack.Id))
+	if err != nil {
+		return "", err
+	}
+
+	if !composeMatchesDeployed(current, deployed) {
+		return stackStatusDrifted, nil
+	}
+
+	return stackStatusInSync, nil
+}
+
+// "" if stack wasn't deployed by us
+// canonical form, so stacks deployed before refs were canonicalized (with refs like
+// "prod5:./stacks/hellohttp.hcl") are still matched to their spec
+func stackJamesRef(stack portainerclient.Stack) string {
+	for _, envPair := range stack.Env {
+		if envPair.Name == "JAMES_REF" {
+			return canonicalJamesRef(envPair.Value)
+		}
+	}
+
+	return ""
+}
+
+// "prod5:./stacks/../stacks/hellohttp.hcl" => "prod5:stacks/hellohttp.hcl"
+func canonicalJamesRef(jamesRef string) string {
+	separatorPos := strings.Index(jamesRef, ":")
+	if separatorPos == -1 {
+		return jamesRef
+	}
+
+	clusterID, specPath := jamesRef[:separatorPos], jamesRef[separatorPos+1:]
+
+	clusterDir, err := os.Getwd()
+	if err != nil {
+		return jamesRef
+	}
+
+	canonical, err := jamesRefRelativeTo(clusterDir, clusterID, specPath)
+	if err != nil {
+		return jamesRef
+	}
+
+	return canonical
+}
+
+// "prod5:stacks/hellohttp.hcl" => "stacks/hellohttp.hcl" (reverse of makeJamesRef())
+func stackSpecPath(jctx *jamestypes.JamesfileCtx, jamesRef string) (string, bool) {
+	clusterPrefix := jctx.ClusterID + ":"
+	if !strings.HasPrefix(jamesRef, clusterPrefix) {
+		return "", false
+	}
+
+	specPath := strings.TrimPrefix(jamesRef, clusterPrefix)
+
+	_, err := os.Stat(specPath)
+
+	return specPath, err == nil
+}
+
+var imageDigestPinRe = regexp.MustCompile(`@sha256:[0-9a-f]+`)
+
+// stacks deployed with --pin-digests have digests that spec's output (without resolving) doesn't
+func composeMatchesDeployed(current string, deployed string) bool {
+	return imageDigestPinRe.ReplaceAllString(current, "") == imageDigestPinRe.ReplaceAllString(deployed, "")
+}
+
 func stackDeployEntry() *cobra.Command {
 	dry := false
 	name := ""
+	pinDigests := false
 
 	cmd := &cobra.Command{
 		Use:   "deploy <path to .hcl>",
 		Short: "Deploys a stack",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			osutil.ExitIfError(stackDeploy(args[0], dry, name, 2))
+			osutil.ExitIfError(stackDeploy(args[0], dry, name, pinDigests, 2))
 		},
 	}
 
 	cmd.Flags().StringVarP(&name, "name", "n", name, "Name of the stack (needed when deploying new stack)")
 	cmd.Flags().BoolVarP(&dry, "dry", "d", dry, "Instead of deploying, just make a dry run (do a diff)")
+	cmd.Flags().BoolVarP(&pinDigests, "pin-digests", "", pinDigests, "Resolve image tags to digests, so re-pushed tags show up in the diff")
 
 	return cmd
 }
 
+func stackLsEntry() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ls",
+		Short: "Lists stacks, with drift status against their specs",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			osutil.ExitIfError(stackLs())
+		},
+	}
+}
+
 func stackRmEntry() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "rm <stackId>",
@@ -190,6 +453,12 @@ func stackEntry() *cobra.Command {
 	}
 
 	cmd.AddCommand(stackDeployEntry())
+	cmd.AddCommand(stackLsEntry())
+	cmd.AddCommand(stackPlanEntry())
+	cmd.AddCommand(stackApplyEntry())
+	cmd.AddCommand(stackReconcileEntry())
+	cmd.AddCommand(stackHistoryEntry())
+	cmd.AddCommand(stackRollbackEntry())
 	cmd.AddCommand(stackRmEntry())
 
 	return cmd
@@ -253,16 +522,121 @@ func makePortainerClient2(
 	return makePortainerClient(*refreshedJctx, false)
 }
 
+// "prod5:stacks/hellohttp.hcl". commands are run from the cluster's directory, so that is what
+// spec path is made relative to
+func makeJamesRef(jctx *jamestypes.JamesfileCtx, specPath string) (string, error) {
+	clusterDir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	return jamesRefRelativeTo(clusterDir, jctx.ClusterID, specPath)
+}
+
+func jamesRefRelativeTo(clusterDir string, clusterID string, specPath string) (string, error) {
+	canonicalPath, err := canonicalSpecPath(clusterDir, specPath)
+	if err != nil {
+		return "", err
+	}
+
+	return clusterID + ":" + canonicalPath, nil
+}
+
+// same spec must always get the same ref, so "./stacks/hellohttp.hcl" and
+// "/home/joonas/prod5/stacks/hellohttp.hcl" (with cluster dir "/home/joonas/prod5") are both
+// "stacks/hellohttp.hcl"
+func canonicalSpecPath(clusterDir string, specPath string) (string, error) {
+	if !filepath.IsAbs(specPath) {
+		specPath = filepath.Join(clusterDir, specPath)
+	}
+
+	relativePath, err := filepath.Rel(clusterDir, specPath)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(relativePath), nil
+}
+
+// rejects spec if it violates a rule (that it has no exemption for) of the repo's policy file
+func enforcePolicy(specPath string, clusterCtx servicespec.ClusterContext) error {
+	policy, err := servicespec.ReadPolicyFileIfExists(policyFilename)
+	if err != nil || policy == nil {
+		return err
+	}
+
+	violations, err := servicespec.CheckPolicyByPath(specPath, clusterCtx, *policy)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		lines := []string{}
+		for _, violation := range violations {
+			lines = append(lines, violation.String())
+		}
+
+		return fmt.Errorf(
+			"%s: policy violations (add policy_exemption with a reason if intended):\n%s",
+			specPath,
+			strings.Join(lines, "\n"))
+	}
+
+	return nil
+}
+
+func specClusterContext(jctx *jamestypes.JamesfileCtx, jamesRef string) servicespec.ClusterContext {
+	return servicespec.ClusterContext{
+		ID:                     jctx.ClusterID,
+		Domain:                 jctx.File.Domain,
+		JamesRef:               jamesRef,
+		IngressDialect:         servicespec.IngressDialect(jctx.Cluster.IngressDialect),
+		LegacyBackupCommandEnv: jctx.Cluster.LegacyBackupCommandEnv,
+		TemplatesDir:           templatesDir,
+		Defaults: servicespec.Defaults{
+			DockerNetworkName: dockerNetworkName(jctx.Cluster),
+			HowToUpdate:       jctx.Cluster.Defaults.HowToUpdate,
+			RamMb:             jctx.Cluster.Defaults.RamMb,
+			Labels:            jctx.Cluster.Defaults.Labels,
+			LoggingDriver:     jctx.Cluster.Defaults.LoggingDriver,
+			LoggingOptions:    jctx.Cluster.Defaults.LoggingOptions,
+		},
+	}
+}
+
+// returns nil if digests are not to be pinned
+func makeImageDigestResolver(
+	ctx context.Context,
+	jctx *jamestypes.JamesfileCtx,
+	pinDigests bool,
+) servicespec.ImageDigestResolver {
+	if !pinDigests {
+		return nil
+	}
+
+	credentials := map[string]registryclient.Credentials{}
+	for registry, creds := range jctx.File.Credentials.Registries {
+		credentials[registry] = registryclient.Credentials{
+			Username: creds.Username,
+			Password: creds.Password,
+		}
+	}
+
+	registry := registryclient.New(credentials)
+
+	return func(image string, tag string) (string, error) {
+		return registry.Digest(ctx, image, tag)
+	}
+}
+
 func findPortainerStackByRef(ref string, endpointID string, stacks []portainerclient.Stack) *portainerclient.Stack {
 	for _, stack := range stacks {
 		if strconv.Itoa(stack.EndpointID) != endpointID {
 			continue
 		}
 
-		for _, envPair := range stack.Env {
-			if envPair.Name == "JAMES_REF" && envPair.Value == ref {
-				return &stack
-			}
+		if stackJamesRef(stack) == ref {
+			return &stack
 		}
 	}
 
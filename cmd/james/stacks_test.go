@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+	"github.com/function61/james/pkg/jamestypes"
+	"github.com/function61/james/pkg/portainerclient"
+)
+
+func TestComposeMatchesDeployed(t *testing.T) {
+	current := "image: joonas/hellohttp:v2\n"
+
+	assert.Assert(t, composeMatchesDeployed(current, "image: joonas/hellohttp:v2\n"))
+	assert.Assert(t, composeMatchesDeployed(current, "image: joonas/hellohttp:v2@sha256:0123456789abcdef\n"))
+	assert.Assert(t, !composeMatchesDeployed(current, "image: joonas/hellohttp:v3\n"))
+}
+
+func TestOrphanedStacks(t *testing.T) {
+	jctx := &jamestypes.JamesfileCtx{
+		ClusterID: "prod5",
+		Cluster:   &jamestypes.ClusterConfig{PortainerEndpointId: "1"},
+	}
+
+	stack := func(name string, endpointID int, jamesRef string) portainerclient.Stack {
+		env := []portainerclient.EnvPair{}
+		if jamesRef != "" {
+			env = append(env, portainerclient.EnvPair{Name: "JAMES_REF", Value: jamesRef})
+		}
+
+		return portainerclient.Stack{Name: name, EndpointID: endpointID, Env: env}
+	}
+
+	stacks := []portainerclient.Stack{
+		stack("hellohttp", 1, "prod5:stacks/hellohttp.hcl"),       // still wanted
+		stack("removed", 1, "prod5:stacks/removed.hcl"),           // orphaned
+		stack("otherdir", 1, "prod5:other/otherdir.hcl"),          // not in planned dir
+		stack("othercluster", 1, "prod6:stacks/othercluster.hcl"), // not our cluster
+		stack("otherendpoint", 2, "prod5:stacks/otherendpoint.hcl"),
+		stack("manual", 1, ""), // unmanaged
+		stack("legacy", 1, "prod5:./stacks/legacy.hcl"),
+	}
+
+	names := func(stacks []portainerclient.Stack) string {
+		result := []string{}
+		for _, stack := range stacks {
+			result = append(result, stack.Name)
+		}
+
+		return strings.Join(result, ",")
+	}
+
+	desiredRefs := map[string]bool{
+		"prod5:stacks/hellohttp.hcl": true,
+		"prod5:stacks/legacy.hcl":    true, // deployed before refs were canonical
+	}
+
+	assert.EqualString(t, names(orphanedStacks(stacks, jctx, "stacks/", desiredRefs)), "removed")
+	assert.EqualString(t, names(orphanedStacks(stacks, jctx, ".", desiredRefs)), "removed,otherdir")
+
+	legacy := findPortainerStackByRef("prod5:stacks/legacy.hcl", "1", stacks)
+	assert.Assert(t, legacy != nil)
+	assert.EqualString(t, legacy.Name, "legacy")
+}
+
+func TestCanonicalSpecPath(t *testing.T) {
+	canonical := func(specPath string) string {
+		canonicalPath, err := canonicalSpecPath("/home/joonas/prod5", specPath)
+		assert.Assert(t, err == nil)
+
+		return canonicalPath
+	}
+
+	assert.EqualString(t, canonical("stacks/hellohttp.hcl"), "stacks/hellohttp.hcl")
+	assert.EqualString(t, canonical("./stacks/../stacks/hellohttp.hcl"), "stacks/hellohttp.hcl")
+	assert.EqualString(t, canonical("/home/joonas/prod5/stacks/hellohttp.hcl"), "stacks/hellohttp.hcl")
+}
@@ -10,7 +10,12 @@ import (
 	"github.com/function61/james/pkg/jamestypes"
 )
 
-const jamesfileFilename = "../jamesfile.json"
+const (
+	jamesfileFilename = "../jamesfile.json"
+	policyFilename    = "../james-policy.hcl" // optional
+	templatesDir      = "../templates"        // optional. spec templates shared by all clusters
+	deployHistoryDir  = "deploy-history"      // per cluster. see deployhistory.go
+)
 
 func readJamesfile() (*jamestypes.JamesfileCtx, error) {
 	jf := jamestypes.Jamesfile{}
@@ -39,6 +44,15 @@ func writeJamesfile(jamesfile *jamestypes.Jamesfile) error {
 	return jsonfile.Write(jamesfileFilename, jamesfile)
 }
 
+// clusters bootstrapped before this was configurable use "fn61"
+func dockerNetworkName(cluster *jamestypes.ClusterConfig) string {
+	if cluster.Defaults.DockerNetworkName == "" {
+		return "fn61"
+	}
+
+	return cluster.Defaults.DockerNetworkName
+}
+
 func findNodeByHostname(j *jamestypes.JamesfileCtx, name string) (*jamestypes.Node, error) {
 	for _, node := range j.File.Clusters[j.ClusterID].Nodes {
 		if node.Name == name {
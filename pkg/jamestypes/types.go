@@ -33,11 +33,24 @@ type Jamesfile struct {
 }
 
 type ClusterConfig struct {
-	ID                   string  `json:"id"`
-	SwarmManagerName     string  `json:"swarm_manager_name"`
-	SwarmJoinTokenWorker string  `json:"swarm_jointoken_worker"`
-	PortainerEndpointId  string  `json:"portainer_endpoint_id"`
-	Nodes                []*Node `json:"nodes"`
+	ID                     string          `json:"id"`
+	SwarmManagerName       string          `json:"swarm_manager_name"`
+	SwarmJoinTokenWorker   string          `json:"swarm_jointoken_worker"`
+	PortainerEndpointId    string          `json:"portainer_endpoint_id"`
+	IngressDialect         string          `json:"ingress_dialect"` // "traefik_v1" (default) | "traefik_v2"
+	Defaults               ClusterDefaults `json:"defaults"`
+	LegacyBackupCommandEnv bool            `json:"legacy_backup_command_env"` // for old ubackup that reads BACKUP_COMMAND env
+	Nodes                  []*Node         `json:"nodes"`
+}
+
+// conventions for stacks deployed to the cluster, for things that specs don't define
+type ClusterDefaults struct {
+	DockerNetworkName string            `json:"docker_network_name"` // "fn61" if not set
+	HowToUpdate       string            `json:"how_to_update"`
+	RamMb             *uint64           `json:"ram_mb"`
+	Labels            map[string]string `json:"labels"`
+	LoggingDriver     string            `json:"logging_driver"`
+	LoggingOptions    map[string]string `json:"logging_options"`
 }
 
 type UsernamePasswordCredentials struct {
@@ -55,6 +68,8 @@ type Credentials struct {
 	WhoisXmlApi  *BareTokenCredential         `json:"whoisxmlapi"`
 	Portainer    *UsernamePasswordCredentials `json:"portainer"`
 	PortainerTok *BareTokenCredential         `json:"portainer_shortlived_bearertoken"`
+	// keyed by registry host ("docker.io", "ghcr.io", "localhost:5000")
+	Registries map[string]*UsernamePasswordCredentials `json:"registries"`
 }
 
 type JamesfileCtx struct {
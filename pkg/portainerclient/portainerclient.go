@@ -196,3 +196,56 @@ func (p *Client) DeleteStack(ctx context.Context, stackId int) error {
 
 	return nil
 }
+
+func (p *Client) ListSecrets(ctx context.Context) ([]FileObject, error) {
+	return p.listFileObjects(ctx, "secrets")
+}
+
+func (p *Client) CreateSecret(ctx context.Context, name string, content []byte) error {
+	return p.createFileObject(ctx, "secrets", name, content)
+}
+
+func (p *Client) ListConfigs(ctx context.Context) ([]FileObject, error) {
+	return p.listFileObjects(ctx, "configs")
+}
+
+func (p *Client) CreateConfig(ctx context.Context, name string, content []byte) error {
+	return p.createFileObject(ctx, "configs", name, content)
+}
+
+// "secrets" | "configs". these go straight to Docker API via Portainer's proxy
+func (p *Client) listFileObjects(ctx context.Context, kind string) ([]FileObject, error) {
+	objects := []FileObject{}
+	if _, err := ezhttp.Get(
+		ctx,
+		fmt.Sprintf("%s/api/endpoints/%s/docker/%s", p.baseUrl, p.endpointId, kind),
+		ezhttp.AuthBearer(p.bearerToken),
+		ezhttp.RespondsJson(&objects, true),
+	); err != nil {
+		return nil, fmt.Errorf("list %s: %w", kind, err)
+	}
+
+	return objects, nil
+}
+
+func (p *Client) createFileObject(ctx context.Context, kind string, name string, content []byte) error {
+	req := struct {
+		Name string
+		Data []byte // Docker expects base64, which is what encoding/json does for []byte
+	}{
+		Name: name,
+		Data: content,
+	}
+
+	// non-2xx error carries sample of response body (= Docker's error message)
+	if _, err := ezhttp.Post(
+		ctx,
+		fmt.Sprintf("%s/api/endpoints/%s/docker/%s/create", p.baseUrl, p.endpointId, kind),
+		ezhttp.AuthBearer(p.bearerToken),
+		ezhttp.SendJson(&req),
+	); err != nil {
+		return fmt.Errorf("create %s %s: %w", kind, name, err)
+	}
+
+	return nil
+}
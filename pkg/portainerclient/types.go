@@ -25,3 +25,11 @@ type Endpoint struct {
 		TotalMemory           int64
 	}
 }
+
+// Swarm secret or config
+type FileObject struct {
+	ID   string
+	Spec struct {
+		Name string
+	}
+}
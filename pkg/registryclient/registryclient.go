@@ -0,0 +1,291 @@
+// Resolves image tags to content digests via Docker Registry HTTP API v2
+package registryclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/function61/gokit/ezhttp"
+)
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+// manifest lists and OCI indexes first, so multi-arch images resolve to the same digest that
+// "$ docker pull" would record (and not to the digest of one platform's manifest)
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+type Credentials struct {
+	Username string
+	Password string
+}
+
+type Client struct {
+	credentials map[string]Credentials // keyed by registry host
+	digests     map[string]string      // cache. "joonas/hellohttp:v2" => "sha256:..."
+}
+
+// credentials are keyed by registry host ("docker.io", "ghcr.io", "localhost:5000")
+func New(credentials map[string]Credentials) *Client {
+	return &Client{
+		credentials: credentials,
+		digests:     map[string]string{},
+	}
+}
+
+// returns "sha256:..."
+func (c *Client) Digest(ctx context.Context, image string, tag string) (string, error) {
+	cacheKey := image + ":" + tag
+
+	if digest, cached := c.digests[cacheKey]; cached {
+		return digest, nil
+	}
+
+	digest, err := c.resolveDigest(ctx, image, tag)
+	if err != nil {
+		return "", fmt.Errorf("Digest %s: %w", cacheKey, err)
+	}
+
+	c.digests[cacheKey] = digest
+
+	return digest, nil
+}
+
+func (c *Client) resolveDigest(ctx context.Context, image string, tag string) (string, error) {
+	registry, repository := ParseImage(image)
+
+	manifestUrl := fmt.Sprintf(
+		"%s://%s/v2/%s/manifests/%s",
+		registryScheme(registry),
+		registry,
+		repository,
+		tag)
+
+	headManifest := func(auth ...ezhttp.ConfigPiece) (*http.Response, error) {
+		resp, err := ezhttp.Head(
+			ctx,
+			manifestUrl,
+			append([]ezhttp.ConfigPiece{
+				ezhttp.Header("Accept", strings.Join(manifestMediaTypes, ", ")),
+				ezhttp.TolerateNon2xxResponse,
+			}, auth...)...)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close() // HEAD has no body
+
+		return resp, nil
+	}
+
+	resp, err := headManifest()
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		auth, err := c.authenticate(ctx, registry, repository, resp.Header.Get("Www-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+
+		resp, err = headManifest(auth)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("manifest: %s", resp.Status)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", errors.New("registry did not return Docker-Content-Digest")
+	}
+
+	return digest, nil
+}
+
+// handles the challenge from a 401 response. registries either want basic auth directly, or
+// a token from an auth server (Docker Hub uses tokens even for anonymous pulls)
+func (c *Client) authenticate(
+	ctx context.Context,
+	registry string,
+	repository string,
+	challenge string,
+) (ezhttp.ConfigPiece, error) {
+	scheme, params := parseChallenge(challenge)
+
+	creds, hasCreds := c.credentials[credentialsKey(registry)]
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasCreds {
+			return ezhttp.ConfigPiece{}, fmt.Errorf("no credentials for registry %s", registry)
+		}
+
+		return ezhttp.AuthBasic(creds.Username, creds.Password), nil
+	case "bearer":
+		token, err := c.fetchToken(ctx, params, repository, creds, hasCreds)
+		if err != nil {
+			return ezhttp.ConfigPiece{}, err
+		}
+
+		return ezhttp.AuthBearer(token), nil
+	default:
+		return ezhttp.ConfigPiece{}, fmt.Errorf("unsupported auth challenge: %s", challenge)
+	}
+}
+
+func (c *Client) fetchToken(
+	ctx context.Context,
+	params map[string]string,
+	repository string,
+	creds Credentials,
+	hasCreds bool,
+) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", errors.New("auth challenge without realm")
+	}
+
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+
+	query := url.Values{}
+	query.Set("scope", scope)
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+
+	// Docker's spec says "token", but OAuth2-compatible servers respond with "access_token"
+	res := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+
+	confPieces := []ezhttp.ConfigPiece{ezhttp.RespondsJson(&res, true)}
+	if hasCreds {
+		confPieces = append(confPieces, ezhttp.AuthBasic(creds.Username, creds.Password))
+	}
+
+	if _, err := ezhttp.Get(ctx, realm+"?"+query.Encode(), confPieces...); err != nil {
+		return "", fmt.Errorf("fetchToken: %w", err)
+	}
+
+	if res.Token != "" {
+		return res.Token, nil
+	}
+
+	if res.AccessToken != "" {
+		return res.AccessToken, nil
+	}
+
+	return "", errors.New("fetchToken: no token in response")
+}
+
+// "joonas/hellohttp" => ("registry-1.docker.io", "joonas/hellohttp")
+// "nginx" => ("registry-1.docker.io", "library/nginx")
+// "localhost:5000/foo/bar" => ("localhost:5000", "foo/bar")
+func ParseImage(image string) (string, string) {
+	parts := strings.SplitN(image, "/", 2)
+
+	// same heuristic as Docker's: first component is a registry if it looks like a hostname
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		if parts[0] == "docker.io" || parts[0] == "index.docker.io" {
+			return dockerHubRegistry, dockerHubRepository(parts[1])
+		}
+
+		return parts[0], parts[1]
+	}
+
+	return dockerHubRegistry, dockerHubRepository(image)
+}
+
+// official images live under "library/"
+func dockerHubRepository(repository string) string {
+	if !strings.Contains(repository, "/") {
+		return "library/" + repository
+	}
+
+	return repository
+}
+
+// users know Docker Hub as "docker.io", not by its API host
+func credentialsKey(registry string) string {
+	if registry == dockerHubRegistry {
+		return "docker.io"
+	}
+
+	return registry
+}
+
+// like Docker, allow plain HTTP for local registries (e.g. "$ docker run -p 5000:5000 registry:2")
+func registryScheme(registry string) string {
+	host := strings.Split(registry, ":")[0]
+
+	if host == "localhost" || host == "127.0.0.1" {
+		return "http"
+	}
+
+	return "https"
+}
+
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"` =>
+// ("Bearer", {realm: "https://auth.docker.io/token", service: "registry.docker.io"})
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+
+	spacePos := strings.Index(challenge, " ")
+	if spacePos == -1 {
+		return challenge, params
+	}
+
+	scheme := challenge[0:spacePos]
+	rest := challenge[spacePos+1:]
+
+	for rest != "" {
+		equalsPos := strings.Index(rest, "=")
+		if equalsPos == -1 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(rest[0:equalsPos]))
+		rest = rest[equalsPos+1:]
+
+		value := ""
+		if strings.HasPrefix(rest, `"`) { // quoted values can contain commas ("pull,push")
+			closingPos := strings.Index(rest[1:], `"`)
+			if closingPos == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:closingPos+1], rest[closingPos+2:]
+			}
+		} else {
+			commaPos := strings.Index(rest, ",")
+			if commaPos == -1 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[0:commaPos], rest[commaPos:]
+			}
+		}
+
+		params[key] = value
+
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+
+	return scheme, params
+}
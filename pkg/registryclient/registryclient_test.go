@@ -0,0 +1,91 @@
+package registryclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestDigestWithTokenAuth(t *testing.T) {
+	var registry *httptest.Server
+
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			username, password, _ := r.BasicAuth()
+			if username != "joonas" || password != "hunter2" || r.URL.Query().Get("scope") != "repository:foo/bar:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			fmt.Fprintln(w, `{"token": "t0k3n"}`)
+		case "/v2/foo/bar/manifests/v1":
+			if r.Header.Get("Authorization") != "Bearer t0k3n" {
+				w.Header().Set("Www-Authenticate", fmt.Sprintf(
+					`Bearer realm="%s/token",service="test",scope="repository:foo/bar:pull"`,
+					registry.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !strings.Contains(r.Header.Get("Accept"), "manifest.list.v2") {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+
+			w.Header().Set("Docker-Content-Digest", "sha256:abcd")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+
+	registryHost := strings.TrimPrefix(registry.URL, "http://")
+
+	client := New(map[string]Credentials{
+		registryHost: {Username: "joonas", Password: "hunter2"},
+	})
+
+	digest, err := client.Digest(context.Background(), registryHost+"/foo/bar", "v1")
+	assert.Assert(t, err == nil)
+	assert.EqualString(t, digest, "sha256:abcd")
+
+	_, err = client.Digest(context.Background(), registryHost+"/foo/bar", "v2")
+	assert.EqualString(t, err.Error(), "Digest "+registryHost+"/foo/bar:v2: manifest: 404 Not Found")
+}
+
+func TestParseImage(t *testing.T) {
+	for _, tc := range []struct {
+		image              string
+		expectedRegistry   string
+		expectedRepository string
+	}{
+		{"nginx", "registry-1.docker.io", "library/nginx"},
+		{"joonas/hellohttp", "registry-1.docker.io", "joonas/hellohttp"},
+		{"docker.io/nginx", "registry-1.docker.io", "library/nginx"},
+		{"ghcr.io/function61/james", "ghcr.io", "function61/james"},
+		{"localhost:5000/foo", "localhost:5000", "foo"},
+	} {
+		tc := tc // pin
+
+		t.Run(tc.image, func(t *testing.T) {
+			registry, repository := ParseImage(tc.image)
+			assert.EqualString(t, registry, tc.expectedRegistry)
+			assert.EqualString(t, repository, tc.expectedRepository)
+		})
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:foo:pull,push"`)
+
+	assert.EqualString(t, scheme, "Bearer")
+	assert.EqualString(t, params["realm"], "https://auth.docker.io/token")
+	assert.EqualString(t, params["service"], "registry.docker.io")
+	assert.EqualString(t, params["scope"], "repository:foo:pull,push")
+}
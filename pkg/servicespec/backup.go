@@ -0,0 +1,134 @@
+package servicespec
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var backupCompressions = []string{"none", "gzip", "zstd"}
+
+// file extensions that tell the content is already compressed
+var compressedFileExtensions = []string{".gz", ".tgz", ".zst", ".xz", ".bz2", ".zip"}
+
+// backup settings are read by ubackup from the service's labels
+func backupLabels(backup Backup) (map[string]string, error) {
+	if err := validateBackup(backup); err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+
+	labels := map[string]string{
+		"ubackup.command": backup.Command,
+	}
+
+	setIfNotEmpty := func(key string, value string) {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+
+	setIfNotEmpty("ubackup.file_extension", backup.FileExtension)
+	setIfNotEmpty("ubackup.schedule", backup.Schedule)
+	setIfNotEmpty("ubackup.compression", backup.Compression)
+	setIfNotEmpty("ubackup.pre_hook", backup.PreHook)
+	setIfNotEmpty("ubackup.post_hook", backup.PostHook)
+
+	if retention := backup.Retention; retention != nil {
+		if retention.Count != nil {
+			labels["ubackup.retention.count"] = strconv.FormatUint(*retention.Count, 10)
+		}
+
+		setIfNotEmpty("ubackup.retention.max_age", retention.MaxAge)
+	}
+
+	return labels, nil
+}
+
+func validateBackup(backup Backup) error {
+	// empty backup section is the explicit opt-out for stateful services
+	if backup.Command == "" {
+		if backup != (Backup{}) {
+			return errors.New("empty command disables backups, but other backup settings are defined")
+		}
+
+		return nil
+	}
+
+	if backup.FileExtension != "" && !strings.HasPrefix(backup.FileExtension, ".") {
+		return fmt.Errorf("file_extension must start with '.': %s", backup.FileExtension)
+	}
+
+	if backup.Compression != "" {
+		if !stringSliceContains(backupCompressions, backup.Compression) {
+			return fmt.Errorf(
+				"unsupported compression: %s (supported: %s)",
+				backup.Compression,
+				strings.Join(backupCompressions, ", "))
+		}
+
+		// would compress already compressed data, or at least mislead about the format
+		if backup.Compression != "none" && hasCompressedFileExtension(backup.FileExtension) {
+			return fmt.Errorf(
+				"compression %s conflicts with already compressed file_extension %s",
+				backup.Compression,
+				backup.FileExtension)
+		}
+	}
+
+	if backup.Schedule != "" {
+		if err := validateCronSchedule(backup.Schedule); err != nil {
+			return err
+		}
+	}
+
+	if retention := backup.Retention; retention != nil {
+		if retention.Count == nil && retention.MaxAge == "" {
+			return errors.New("retention: define count and/or max_age")
+		}
+
+		if retention.Count != nil && *retention.Count == 0 {
+			return errors.New("retention: count must be > 0")
+		}
+
+		if _, err := parseOptionalDuration(retention.MaxAge); err != nil {
+			return fmt.Errorf("retention: max_age: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// "0 3 * * *" or one of the "@daily" style shorthands
+func validateCronSchedule(schedule string) error {
+	switch schedule {
+	case "@yearly", "@annually", "@monthly", "@weekly", "@daily", "@midnight", "@hourly":
+		return nil
+	}
+
+	if fields := strings.Fields(schedule); len(fields) != 5 {
+		return fmt.Errorf("schedule: expecting 5 fields (minute hour day month weekday); got %d", len(fields))
+	}
+
+	return nil
+}
+
+func hasCompressedFileExtension(fileExtension string) bool {
+	for _, compressedExtension := range compressedFileExtensions {
+		if strings.HasSuffix(fileExtension, compressedExtension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stringSliceContains(items []string, item string) bool {
+	for _, candidate := range items {
+		if candidate == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,325 @@
+package servicespec
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	composetypes "github.com/docker/cli/cli/compose/types"
+)
+
+// friendlier names for diff output
+var composeDiffFieldAliases = map[string]string{
+	"environment": "env",
+}
+
+// lists where order matters (compared as a whole instead of item by item)
+var composeDiffOrderedLists = map[reflect.Type]bool{
+	reflect.TypeOf(composetypes.ShellCommand{}):    true,
+	reflect.TypeOf(composetypes.HealthCheckTest{}): true,
+}
+
+// Compares two compose files service by service and field by field, so reordered keys don't
+// show up as changes. returns lines like:
+//
+//	service web: image foo:1.2 -> foo:1.3
+//	service web: env X added: bar
+//	service worker removed
+func DiffCompose(previous string, updated string) ([]string, error) {
+	previousCompose, err := parseComposeFile([]byte(previous))
+	if err != nil {
+		return nil, fmt.Errorf("previous: %w", err)
+	}
+
+	updatedCompose, err := parseComposeFile([]byte(updated))
+	if err != nil {
+		return nil, fmt.Errorf("updated: %w", err)
+	}
+
+	changes := []string{}
+
+	report := func(prefix string) func(string) {
+		return func(change string) {
+			changes = append(changes, prefix+change)
+		}
+	}
+
+	previousServices := composeServicesByName(previousCompose.Services)
+	updatedServices := composeServicesByName(updatedCompose.Services)
+
+	for _, name := range sortedKeysOfServices(previousServices, updatedServices) {
+		previousService, inPrevious := previousServices[name]
+		updatedService, inUpdated := updatedServices[name]
+
+		switch {
+		case !inPrevious:
+			changes = append(changes, fmt.Sprintf("service %s added", name))
+		case !inUpdated:
+			changes = append(changes, fmt.Sprintf("service %s removed", name))
+		default:
+			diffComposeValues(
+				"",
+				reflect.ValueOf(previousService),
+				reflect.ValueOf(updatedService),
+				report(fmt.Sprintf("service %s: ", name)))
+		}
+	}
+
+	// rest of the file (version, volumes, secrets etc.)
+	previousCompose.Services = nil
+	updatedCompose.Services = nil
+
+	diffComposeValues("", reflect.ValueOf(*previousCompose), reflect.ValueOf(*updatedCompose), report(""))
+
+	return changes, nil
+}
+
+func diffComposeValues(path string, previous reflect.Value, updated reflect.Value, report func(string)) {
+	if reflect.DeepEqual(previous.Interface(), updated.Interface()) {
+		return
+	}
+
+	changed := func() {
+		report(fmt.Sprintf("%s %s -> %s", path, formatComposeValue(previous), formatComposeValue(updated)))
+	}
+
+	switch previous.Kind() {
+	case reflect.Ptr:
+		if previous.IsNil() || updated.IsNil() {
+			changed()
+		} else {
+			diffComposeValues(path, previous.Elem(), updated.Elem(), report)
+		}
+	case reflect.Struct:
+		for i := 0; i < previous.NumField(); i++ {
+			name, inline, skip := composeFieldName(previous.Type().Field(i))
+			if skip {
+				continue
+			}
+
+			fieldPath := joinComposePath(path, name)
+			if inline {
+				fieldPath = path
+			}
+
+			diffComposeValues(fieldPath, previous.Field(i), updated.Field(i), report)
+		}
+	case reflect.Map:
+		for _, key := range sortedMapKeys(previous, updated) {
+			previousItem := previous.MapIndex(key)
+			updatedItem := updated.MapIndex(key)
+			itemPath := strings.TrimSpace(path + " " + fmt.Sprint(key.Interface())) // "env FOO"
+
+			switch {
+			case !previousItem.IsValid():
+				report(fmt.Sprintf("%s added%s", itemPath, formatAddedOrRemoved(updatedItem)))
+			case !updatedItem.IsValid():
+				report(fmt.Sprintf("%s removed%s", itemPath, formatAddedOrRemoved(previousItem)))
+			default:
+				diffComposeValues(itemPath, previousItem, updatedItem, report)
+			}
+		}
+	case reflect.Slice:
+		if composeDiffOrderedLists[previous.Type()] {
+			changed()
+			return
+		}
+
+		diffComposeLists(path, previous, updated, report)
+	case reflect.Interface:
+		if previous.IsNil() || updated.IsNil() || previous.Elem().Type() != updated.Elem().Type() {
+			changed()
+		} else {
+			diffComposeValues(path, previous.Elem(), updated.Elem(), report)
+		}
+	default:
+		changed()
+	}
+}
+
+// items are compared by their formatted form, so a changed item shows as removed + added
+func diffComposeLists(path string, previous reflect.Value, updated reflect.Value, report func(string)) {
+	count := func(list reflect.Value) map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < list.Len(); i++ {
+			counts[formatComposeValue(list.Index(i))]++
+		}
+
+		return counts
+	}
+
+	previousCounts := count(previous)
+	updatedCounts := count(updated)
+
+	anyChanges := false
+
+	for i := 0; i < previous.Len(); i++ {
+		item := formatComposeValue(previous.Index(i))
+		if updatedCounts[item] > 0 {
+			updatedCounts[item]--
+			continue
+		}
+
+		report(fmt.Sprintf("%s %s removed", path, item))
+		anyChanges = true
+	}
+
+	for i := 0; i < updated.Len(); i++ {
+		item := formatComposeValue(updated.Index(i))
+		if previousCounts[item] > 0 {
+			previousCounts[item]--
+			continue
+		}
+
+		report(fmt.Sprintf("%s %s added", path, item))
+		anyChanges = true
+	}
+
+	if !anyChanges { // same items, but not equal => order differs
+		report(fmt.Sprintf("%s reordered", path))
+	}
+}
+
+// (name, isInline, skip) from the field's YAML tag
+func composeFieldName(field reflect.StructField) (string, bool, bool) {
+	if field.PkgPath != "" { // unexported
+		return "", false, true
+	}
+
+	tagParts := strings.Split(field.Tag.Get("yaml"), ",")
+
+	switch {
+	case tagParts[0] == "-":
+		return "", false, true
+	case len(tagParts) > 1 && tagParts[1] == "inline":
+		return "", true, false
+	}
+
+	name := tagParts[0]
+	if name == "" {
+		name = strings.ToLower(field.Name) // go-yaml's default
+	}
+
+	if alias, found := composeDiffFieldAliases[name]; found {
+		name = alias
+	}
+
+	return name, false, false
+}
+
+// "deploy" + "replicas" => "deploy.replicas"
+func joinComposePath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+func formatAddedOrRemoved(value reflect.Value) string {
+	formatted := formatComposeValue(value)
+	if formatted == "(none)" {
+		return ""
+	}
+
+	return ": " + formatted
+}
+
+// compact, single-line representation
+func formatComposeValue(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return "(none)"
+		}
+
+		return formatComposeValue(value.Elem())
+	case reflect.Struct:
+		if stringer, isStringer := value.Interface().(fmt.Stringer); isStringer {
+			return stringer.String()
+		}
+
+		fields := []string{}
+		for i := 0; i < value.NumField(); i++ {
+			name, _, skip := composeFieldName(value.Type().Field(i))
+			if skip || value.Field(i).IsZero() {
+				continue
+			}
+
+			fields = append(fields, name+": "+formatComposeValue(value.Field(i)))
+		}
+
+		return "{" + strings.Join(fields, ", ") + "}"
+	case reflect.Slice:
+		items := []string{}
+		for i := 0; i < value.Len(); i++ {
+			items = append(items, formatComposeValue(value.Index(i)))
+		}
+
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		items := []string{}
+		for _, key := range sortedMapKeys(value, value) {
+			items = append(items, fmt.Sprintf("%v: %s", key.Interface(), formatComposeValue(value.MapIndex(key))))
+		}
+
+		return "{" + strings.Join(items, ", ") + "}"
+	case reflect.String:
+		if value.String() == "" {
+			return `""`
+		}
+
+		return value.String()
+	default:
+		return fmt.Sprint(value.Interface())
+	}
+}
+
+func composeServicesByName(services composetypes.Services) map[string]composetypes.ServiceConfig {
+	byName := map[string]composetypes.ServiceConfig{}
+	for _, service := range services {
+		byName[service.Name] = service
+	}
+
+	return byName
+}
+
+func sortedKeysOfServices(a map[string]composetypes.ServiceConfig, b map[string]composetypes.ServiceConfig) []string {
+	names := []string{}
+	for name := range a {
+		names = append(names, name)
+	}
+
+	for name := range b {
+		if _, found := a[name]; !found {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// union of keys of both maps
+func sortedMapKeys(a reflect.Value, b reflect.Value) []reflect.Value {
+	keys := []reflect.Value{}
+	seen := map[string]bool{}
+
+	for _, m := range []reflect.Value{a, b} {
+		for _, key := range m.MapKeys() {
+			keyStr := fmt.Sprint(key.Interface())
+			if !seen[keyStr] {
+				seen[keyStr] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	return keys
+}
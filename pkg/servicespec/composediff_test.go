@@ -0,0 +1,76 @@
+package servicespec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestDiffCompose(t *testing.T) {
+	previous := `version: "3.5"
+services:
+  web:
+    image: foo:1.2
+    environment:
+      FOO: bar
+      REMOVED: x
+    deploy:
+      replicas: 1
+      labels:
+        traefik.port: "80"
+    ports:
+    - target: 80
+      published: 8080
+  worker:
+    image: worker:1
+volumes:
+  data: {}
+`
+
+	// keys reordered on purpose, they must not show up as changes
+	updated := `services:
+  web:
+    deploy:
+      labels:
+        traefik.port: "8080"
+      replicas: 2
+    environment:
+      REMOVED: x
+      FOO: baz
+      ADDED: hello
+    image: foo:1.3
+    ports:
+    - target: 80
+      published: 8080
+    - target: 443
+      published: 443
+      mode: host
+    command: ["nginx", "-g", "daemon off;"]
+  cron:
+    image: cron:1
+version: "3.6"
+volumes:
+  data: {}
+`
+
+	changes, err := DiffCompose(previous, updated)
+	assert.Assert(t, err == nil)
+
+	assert.EqualString(t, strings.Join(changes, "\n"), `service cron added
+service web: command [] -> [nginx, -g, daemon off;]
+service web: deploy.replicas 1 -> 2
+service web: deploy.labels traefik.port 80 -> 8080
+service web: env ADDED added: hello
+service web: env FOO bar -> baz
+service web: image foo:1.2 -> foo:1.3
+service web: ports {mode: host, target: 443, published: 443} added
+service worker removed
+version 3.5 -> 3.6`)
+}
+
+func TestDiffComposeUnchanged(t *testing.T) {
+	changes, err := DiffCompose("version: \"3.5\"\nservices:\n  web:\n    image: foo\n", "version: \"3.5\"\nservices:\n  web:\n    image: foo\n")
+	assert.Assert(t, err == nil)
+	assert.Assert(t, len(changes) == 0)
+}
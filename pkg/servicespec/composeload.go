@@ -0,0 +1,33 @@
+package servicespec
+
+import (
+	"sort"
+
+	"github.com/docker/cli/cli/compose/loader"
+	composetypes "github.com/docker/cli/cli/compose/types"
+)
+
+// Docker's own loader, so short syntaxes (ports, volumes, ulimits, environment etc.) are
+// normalized exactly like $ docker stack deploy does
+func parseComposeFile(content []byte) (*composetypes.Config, error) {
+	dict, err := loader.ParseYAML(content)
+	if err != nil {
+		return nil, err
+	}
+
+	compose, err := loader.Load(composetypes.ConfigDetails{
+		WorkingDir:  ".",
+		ConfigFiles: []composetypes.ConfigFile{{Config: dict}},
+		Environment: map[string]string{}, // not the deployer's, so nothing is interpolated from it
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// loader returns them in map iteration order
+	sort.Slice(compose.Services, func(i, j int) bool {
+		return compose.Services[i].Name < compose.Services[j].Name
+	})
+
+	return compose, nil
+}
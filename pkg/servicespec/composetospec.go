@@ -0,0 +1,718 @@
+package servicespec
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	composetypes "github.com/docker/cli/cli/compose/types"
+)
+
+// Converts compose YAML into spec HCL. returns also list of things the spec format can't
+// express (these are left out of the spec, so they need human attention).
+func ComposeToSpec(content []byte) ([]byte, []string, error) {
+	compose, err := parseComposeFile(content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec, unsupported := composeConfigToSpec(compose)
+
+	hclContent, err := encodeHcl(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// encoder writes in struct field order, which mixes attributes and blocks
+	formatted, err := FormatSpec(hclContent, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return formatted, unsupported, nil
+}
+
+func composeConfigToSpec(compose *composetypes.Config) (*SpecFile, []string) {
+	spec := &SpecFile{}
+	unsupported := []string{}
+
+	for _, composeService := range compose.Services {
+		report := func(format string, args ...interface{}) {
+			unsupported = append(
+				unsupported,
+				fmt.Sprintf("service %s: ", composeService.Name)+fmt.Sprintf(format, args...))
+		}
+
+		service, isGlobal := composeServiceToSpec(composeService, compose, spec, report)
+
+		if isGlobal {
+			spec.GlobalServices = append(spec.GlobalServices, *service)
+		} else {
+			spec.Services = append(spec.Services, *service)
+		}
+	}
+
+	for name, network := range compose.Networks {
+		if !network.External.External && network.External.Name == "" {
+			unsupported = append(unsupported, fmt.Sprintf(
+				"network %s: stack-defined networks (services join the cluster's network)",
+				name))
+		}
+	}
+
+	for name, volume := range compose.Volumes {
+		if !reflect.DeepEqual(volume, composetypes.VolumeConfig{}) {
+			unsupported = append(unsupported, fmt.Sprintf(
+				"volume %s: volume options (only plain named volumes are supported)",
+				name))
+		}
+	}
+
+	sort.Strings(unsupported)
+
+	return spec, unsupported
+}
+
+// unsupported things are reported via report()
+func composeServiceToSpec(
+	composeService composetypes.ServiceConfig,
+	compose *composetypes.Config,
+	spec *SpecFile,
+	report func(format string, args ...interface{}),
+) (*ServiceSpec, bool) {
+	// we'll clear fields that we've converted. what's left over is unsupported
+	leftover := composeService
+	leftover.Name = ""
+
+	imageRef := composeService.Image
+	if atPos := strings.Index(imageRef, "@"); atPos != -1 {
+		report("image digest %s (pin at deploy time instead)", imageRef[atPos+1:])
+		imageRef = imageRef[0:atPos]
+	}
+
+	image, version := splitImageRef(imageRef)
+	leftover.Image = ""
+
+	service := &ServiceSpec{
+		Name:       composeService.Name,
+		Image:      image,
+		Version:    version,
+		Command:    composeService.Command,
+		Privileged: composeService.Privileged,
+		Devices:    composeService.Devices,
+		User:       composeService.User,
+		Caps:       composeService.CapAdd,
+	}
+	leftover.Command = nil
+	leftover.Privileged = false
+	leftover.Devices = nil
+	leftover.User = ""
+	leftover.CapAdd = nil
+
+	isGlobal := composeService.Deploy.Mode == "global"
+	leftover.Deploy.Mode = ""
+
+	if !isGlobal {
+		service.Replicas = composeService.Deploy.Replicas
+	}
+	leftover.Deploy.Replicas = nil
+
+	for _, key := range sortedEnvKeys(composeService.Environment) {
+		value := composeService.Environment[key]
+		if value == nil {
+			report("env %s: value from deployer's environment", key)
+			continue
+		}
+
+		if key == "LOGGER_SUPPRESS_TIMESTAMPS" && *value == "1" {
+			continue // we add this automatically
+		}
+
+		service.ENVs = append(service.ENVs, EnvVar{
+			Key:   key,
+			Value: *value,
+		})
+	}
+	leftover.Environment = nil
+
+	service.HowToUpdate = composeUpdateConfigToSpec(composeService, spec, report)
+	leftover.Deploy.UpdateConfig = nil
+	leftover.Deploy.RollbackConfig = nil
+
+	ramMb := uint64(0) // explicit "no limit", so cluster's default doesn't apply
+	if limits := composeService.Deploy.Resources.Limits; limits != nil {
+		ramMb = uint64(limits.MemoryBytes / 1024 / 1024)
+		service.Cpus = parseCpus(limits.NanoCPUs, report)
+	}
+	service.RamMb = &ramMb
+
+	if reservations := composeService.Deploy.Resources.Reservations; reservations != nil {
+		if reservations.MemoryBytes != 0 {
+			ramReservationMb := uint64(reservations.MemoryBytes / 1024 / 1024)
+			service.RamReservationMb = &ramReservationMb
+		}
+		service.CpusReservation = parseCpus(reservations.NanoCPUs, report)
+	}
+
+	for _, resources := range []*composetypes.Resource{
+		composeService.Deploy.Resources.Limits,
+		composeService.Deploy.Resources.Reservations,
+	} {
+		if resources != nil && len(resources.GenericResources) > 0 {
+			report("deploy.resources: generic_resources")
+		}
+	}
+	leftover.Deploy.Resources = composetypes.Resources{}
+
+	if healthcheck := composeService.HealthCheck; healthcheck != nil {
+		service.Healthcheck = composeHealthcheckToSpec(*healthcheck, report)
+	}
+	leftover.HealthCheck = nil
+
+	if restartPolicy := composeService.Deploy.RestartPolicy; restartPolicy != nil {
+		service.RestartPolicy = &RestartPolicy{
+			Condition:   restartPolicy.Condition,
+			Delay:       formatOptionalDuration(restartPolicy.Delay),
+			MaxAttempts: restartPolicy.MaxAttempts,
+			Window:      formatOptionalDuration(restartPolicy.Window),
+		}
+	}
+	leftover.Deploy.RestartPolicy = nil
+
+	service.StopGracePeriod = formatOptionalDuration(composeService.StopGracePeriod)
+	service.StopSignal = composeService.StopSignal
+	leftover.StopGracePeriod = nil
+	leftover.StopSignal = ""
+
+	labelsToSpec(composeService, service, report)
+	leftover.Labels = nil
+	leftover.Deploy.Labels = nil
+
+	for _, constraint := range composeService.Deploy.Placement.Constraints {
+		hostnameConstraint := strings.Split(strings.Replace(constraint, " ", "", -1), "==")
+		if len(hostnameConstraint) == 2 && hostnameConstraint[0] == "node.hostname" && service.PlacementNodeHostname == "" {
+			service.PlacementNodeHostname = hostnameConstraint[1]
+		} else {
+			report("placement constraint %s", constraint)
+		}
+	}
+	if len(composeService.Deploy.Placement.Preferences) > 0 {
+		report("placement preferences")
+	}
+	leftover.Deploy.Placement = composetypes.Placement{}
+
+	service.PidHost = composeService.Pid == "host"
+	leftover.Pid = ""
+
+	for networkName := range composeService.Networks {
+		switch {
+		case networkName == "host":
+			service.NetHost = true
+		case networkName == "default":
+		default:
+			report("network %s (services join the cluster's network)", networkName)
+		}
+	}
+	leftover.Networks = nil
+
+	for _, port := range composeService.Ports {
+		specPort := Port{
+			Public:    port.Published,
+			Container: port.Target,
+		}
+
+		switch port.Mode {
+		case "", portModeIngress:
+		case portModeHost:
+			specPort.Mode = portModeHost
+		default:
+			report("port %d: mode %s", port.Target, port.Mode)
+			continue
+		}
+
+		switch port.Protocol {
+		case "", "tcp":
+			service.TcpPorts = appendPortCollapsingRange(service.TcpPorts, specPort)
+		case "udp":
+			service.UdpPorts = appendPortCollapsingRange(service.UdpPorts, specPort)
+		default:
+			report("port %d: protocol %s", port.Target, port.Protocol)
+		}
+	}
+	leftover.Ports = nil
+
+	for _, volume := range composeService.Volumes {
+		switch {
+		case volume.Type == "bind":
+			service.BindMounts = append(service.BindMounts, BindMount{
+				Host:      volume.Source,
+				Container: volume.Target,
+				ReadOnly:  volume.ReadOnly,
+			})
+		case volume.Type == "volume" && volume.Source != "" && !volume.ReadOnly:
+			service.PersistentVolumes = append(service.PersistentVolumes, PersistentVolume{
+				Name:   volume.Source,
+				Target: volume.Target,
+			})
+		case volume.Type == "tmpfs":
+			tmpfs := Tmpfs{Target: volume.Target}
+			if volume.Tmpfs != nil && volume.Tmpfs.Size != 0 {
+				sizeMb := uint64(volume.Tmpfs.Size) / 1024 / 1024
+				tmpfs.SizeMb = &sizeMb
+			}
+
+			service.Tmpfs = append(service.Tmpfs, tmpfs)
+		default:
+			report("volume %s: type %s (or anonymous/read-only volume)", volume.Target, volume.Type)
+		}
+
+		if volume.Bind != nil || volume.Volume != nil || volume.Consistency != "" {
+			report("volume %s: volume options", volume.Target)
+		}
+	}
+	leftover.Volumes = nil
+
+	// stateful services must say whether they're backed up. compose had no backup labels, so
+	// explicitly opt out (which is what the compose did) and let the user decide
+	if len(service.PersistentVolumes) > 0 && service.Backup == nil {
+		service.Backup = &Backup{}
+		report("no backup labels (added empty backup block, which disables backups)")
+	}
+
+	// short syntax. Swarm ignores this, but the intent is clear
+	for _, target := range composeService.Tmpfs {
+		if strings.Contains(target, ":") {
+			report("tmpfs %s: options", target)
+			continue
+		}
+
+		service.Tmpfs = append(service.Tmpfs, Tmpfs{Target: target})
+	}
+	leftover.Tmpfs = nil
+
+	ulimitNames := []string{}
+	for name := range composeService.Ulimits {
+		ulimitNames = append(ulimitNames, name)
+	}
+	sort.Strings(ulimitNames)
+
+	for _, name := range ulimitNames {
+		ulimit := composeService.Ulimits[name]
+
+		if ulimit.Single != 0 {
+			service.Ulimits = append(service.Ulimits, Ulimit{Name: name, Soft: ulimit.Single, Hard: ulimit.Single})
+		} else {
+			service.Ulimits = append(service.Ulimits, Ulimit{Name: name, Soft: ulimit.Soft, Hard: ulimit.Hard})
+		}
+	}
+	leftover.Ulimits = nil
+
+	for _, sysctl := range composeService.Sysctls {
+		equalsPos := strings.Index(sysctl, "=")
+		if equalsPos == -1 {
+			report("sysctl %s: without value", sysctl)
+			continue
+		}
+
+		service.Sysctls = append(service.Sysctls, Sysctl{
+			Key:   sysctl[0:equalsPos],
+			Value: sysctl[equalsPos+1:],
+		})
+	}
+	leftover.Sysctls = nil
+
+	for _, extraHost := range composeService.ExtraHosts {
+		// IPv6 addresses contain colons, hostnames don't
+		colonPos := strings.Index(extraHost, ":")
+		if colonPos == -1 {
+			report("extra_host %s: without IP", extraHost)
+			continue
+		}
+
+		service.ExtraHosts = append(service.ExtraHosts, ExtraHost{
+			Hostname: extraHost[0:colonPos],
+			IP:       extraHost[colonPos+1:],
+		})
+	}
+	leftover.ExtraHosts = nil
+
+	for _, secret := range composeService.Secrets {
+		mount, ok := fileReferenceToSpec(
+			composetypes.FileReferenceConfig(secret),
+			compose.Secrets[secret.Source].File,
+			report)
+		if ok {
+			service.Secrets = append(service.Secrets, *mount)
+		}
+	}
+	leftover.Secrets = nil
+
+	for _, config := range composeService.Configs {
+		mount, ok := fileReferenceToSpec(
+			composetypes.FileReferenceConfig(config),
+			compose.Configs[config.Source].File,
+			report)
+		if ok {
+			service.Configs = append(service.Configs, *mount)
+		}
+	}
+	leftover.Configs = nil
+
+	for _, key := range unsupportedFieldNames(reflect.ValueOf(leftover), "") {
+		report("%s", key)
+	}
+
+	return service, isGlobal
+}
+
+// builtin strategy if one matches exactly, otherwise defines a new update_strategy in spec
+func composeUpdateConfigToSpec(
+	composeService composetypes.ServiceConfig,
+	spec *SpecFile,
+	report func(format string, args ...interface{}),
+) string {
+	updateConfig := composeService.Deploy.UpdateConfig
+	rollbackConfig := composeService.Deploy.RollbackConfig
+
+	if updateConfig == nil {
+		// Swarm's default is to stop old first, one task at a time
+		updateConfig = knownUpdateConfigs["stop-old-first"]
+	}
+
+	if rollbackConfig == nil {
+		for name, known := range knownUpdateConfigs {
+			if reflect.DeepEqual(*known, *updateConfig) {
+				return name
+			}
+		}
+	}
+
+	strategy := UpdateStrategy{
+		Name:           composeService.Name,
+		UpdateSettings: composeUpdateConfigToSettings(*updateConfig),
+	}
+
+	if rollbackConfig != nil {
+		rollback := composeUpdateConfigToSettings(*rollbackConfig)
+		strategy.Rollback = &rollback
+	} else {
+		report("deploy.rollback_config: Swarm's defaults (will be same as update_config)")
+	}
+
+	spec.UpdateStrategies = append(spec.UpdateStrategies, strategy)
+
+	return strategy.Name
+}
+
+func composeUpdateConfigToSettings(updateConfig composetypes.UpdateConfig) UpdateSettings {
+	return UpdateSettings{
+		Parallelism:     updateConfig.Parallelism,
+		Delay:           formatOptionalDuration(&updateConfig.Delay),
+		Order:           updateConfig.Order,
+		FailureAction:   updateConfig.FailureAction,
+		Monitor:         formatOptionalDuration(&updateConfig.Monitor),
+		MaxFailureRatio: updateConfig.MaxFailureRatio,
+	}
+}
+
+func composeHealthcheckToSpec(
+	healthcheck composetypes.HealthCheckConfig,
+	report func(format string, args ...interface{}),
+) *Healthcheck {
+	if healthcheck.Disable || len(healthcheck.Test) == 0 || healthcheck.Test[0] == "NONE" {
+		report("healthcheck: disabling image's healthcheck")
+		return nil
+	}
+
+	var command []string
+	switch healthcheck.Test[0] {
+	case "CMD":
+		command = healthcheck.Test[1:]
+	case "CMD-SHELL":
+		command = append([]string{"/bin/sh", "-c"}, healthcheck.Test[1:]...)
+	default:
+		report("healthcheck: test %v", healthcheck.Test)
+		return nil
+	}
+
+	return &Healthcheck{
+		Command:     command,
+		Interval:    formatOptionalDuration(healthcheck.Interval),
+		Timeout:     formatOptionalDuration(healthcheck.Timeout),
+		Retries:     healthcheck.Retries,
+		StartPeriod: formatOptionalDuration(healthcheck.StartPeriod),
+	}
+}
+
+// labels that we generate from ingress and backup blocks
+func labelsToSpec(
+	composeService composetypes.ServiceConfig,
+	service *ServiceSpec,
+	report func(format string, args ...interface{}),
+) {
+	// we write labels to both places, so read both
+	labels := map[string]string{}
+	for key, value := range composeService.Labels {
+		labels[key] = value
+	}
+	for key, value := range composeService.Deploy.Labels {
+		labels[key] = value
+	}
+
+	take := func(key string) string {
+		value := labels[key]
+		delete(labels, key)
+		return value
+	}
+
+	if _, has := labels["ubackup.command"]; has {
+		service.Backup = labelsToBackup(take, report)
+	}
+
+	if _, hasRule := labels["traefik.frontend.rule"]; hasRule {
+		ingress := SharedIngressSettings{
+			Rule: take("traefik.frontend.rule"),
+		}
+
+		if portStr := take("traefik.port"); portStr != "" {
+			port, err := strconv.Atoi(portStr)
+			if err != nil {
+				report("label traefik.port: %s", portStr)
+			} else {
+				ingress.Port = &port
+			}
+		}
+
+		switch auth := take("edgerouter.auth"); auth {
+		case "public":
+			service.IngressPublic = &IngressPublic{SharedIngressSettings: ingress}
+		case "bearer_token":
+			service.IngressBearer = &IngressBearer{
+				SharedIngressSettings: ingress,
+				Token:                 take("edgerouter.auth_bearer_token"),
+			}
+		case "sso":
+			service.IngressSso = &IngressSso{
+				SharedIngressSettings: ingress,
+				Tenant:                take("edgerouter.auth_sso.tenant"),
+				Users:                 strings.Split(take("edgerouter.auth_sso.users"), ","),
+			}
+		default: // ingress without explicit auth is not something we want to generate
+			report("ingress with edgerouter.auth=%q", auth)
+		}
+	}
+
+	// named ingresses use segment labels ("traefik.<name>.frontend.rule")
+	segmentNames := []string{}
+	for key := range labels {
+		if parts := strings.Split(key, "."); len(parts) == 4 && parts[0] == "traefik" && parts[2] == "frontend" && parts[3] == "rule" {
+			segmentNames = append(segmentNames, parts[1])
+		}
+	}
+	sort.Strings(segmentNames)
+
+	for _, name := range segmentNames {
+		ingress := Ingress{
+			Name: name,
+			Auth: take("edgerouter." + name + ".auth"),
+			SharedIngressSettings: SharedIngressSettings{
+				Rule: take("traefik." + name + ".frontend.rule"),
+			},
+		}
+
+		if portStr := take("traefik." + name + ".port"); portStr != "" {
+			port, err := strconv.Atoi(portStr)
+			if err != nil {
+				report("label traefik.%s.port: %s", name, portStr)
+			} else {
+				ingress.Port = &port
+			}
+		}
+
+		switch ingress.Auth {
+		case ingressAuthPublic:
+		case ingressAuthBearerToken:
+			ingress.Token = take("edgerouter." + name + ".auth_bearer_token")
+		case ingressAuthSso:
+			ingress.Tenant = take("edgerouter." + name + ".auth_sso.tenant")
+			ingress.Users = strings.Split(take("edgerouter."+name+".auth_sso.users"), ",")
+		default: // ingress without explicit auth is not something we want to generate
+			report("ingress %s with edgerouter.%s.auth=%q", name, name, ingress.Auth)
+			continue
+		}
+
+		service.Ingresses = append(service.Ingresses, ingress)
+	}
+
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		report("label %s", key)
+	}
+}
+
+// inverse of backupLabels()
+func labelsToBackup(
+	take func(key string) string,
+	report func(format string, args ...interface{}),
+) *Backup {
+	backup := &Backup{
+		Command:       take("ubackup.command"),
+		FileExtension: take("ubackup.file_extension"),
+		Schedule:      take("ubackup.schedule"),
+		Compression:   take("ubackup.compression"),
+		PreHook:       take("ubackup.pre_hook"),
+		PostHook:      take("ubackup.post_hook"),
+	}
+
+	retention := &BackupRetention{
+		MaxAge: take("ubackup.retention.max_age"),
+	}
+
+	if countStr := take("ubackup.retention.count"); countStr != "" {
+		count, err := strconv.ParseUint(countStr, 10, 64)
+		if err != nil {
+			report("label ubackup.retention.count: %s", countStr)
+		} else {
+			retention.Count = &count
+		}
+	}
+
+	if retention.Count != nil || retention.MaxAge != "" {
+		backup.Retention = retention
+	}
+
+	return backup
+}
+
+func fileReferenceToSpec(
+	reference composetypes.FileReferenceConfig,
+	file string,
+	report func(format string, args ...interface{}),
+) (*FileObjectMount, bool) {
+	if file == "" {
+		report("%s: only file-based secrets/configs are supported", reference.Source)
+		return nil, false
+	}
+
+	if reference.UID != "" || reference.GID != "" || reference.Mode != nil {
+		report("%s: uid, gid and mode", reference.Source)
+	}
+
+	return &FileObjectMount{
+		Name:   reference.Source,
+		File:   file,
+		Target: reference.Target,
+	}, true
+}
+
+// "fn61/grafana:1.2" => ("fn61/grafana", "1.2")
+func splitImageRef(ref string) (string, string) {
+	lastColon := strings.LastIndex(ref, ":")
+	// colon could be from registry's port ("localhost:5000/foo")
+	if lastColon == -1 || strings.Contains(ref[lastColon:], "/") {
+		return ref, "latest"
+	}
+
+	return ref[0:lastColon], ref[lastColon+1:]
+}
+
+// inverse of parseOptionalDuration(). nil and zero => ""
+func formatOptionalDuration(duration *time.Duration) string {
+	if duration == nil || *duration == 0 {
+		return ""
+	}
+
+	return duration.String()
+}
+
+func parseCpus(nanoCpus string, report func(format string, args ...interface{})) *float64 {
+	if nanoCpus == "" {
+		return nil
+	}
+
+	cpus, err := strconv.ParseFloat(nanoCpus, 64)
+	if err != nil {
+		report("cpus: %s", nanoCpus)
+		return nil
+	}
+
+	return &cpus
+}
+
+func sortedEnvKeys(envs composetypes.MappingWithEquals) []string {
+	keys := []string{}
+	for key := range envs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// lists YAML keys of non-zero fields, e.g. "deploy.restart_policy"
+func unsupportedFieldNames(val reflect.Value, prefix string) []string {
+	names := []string{}
+
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		fieldVal := val.Field(i)
+
+		if isZeroValue(fieldVal) {
+			continue
+		}
+
+		yamlName := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if yamlName == "-" {
+			continue
+		}
+
+		if yamlName == "" {
+			if field.Name == "Extras" { // unknown keys
+				for key := range fieldVal.Interface().(map[string]interface{}) {
+					names = append(names, prefix+key)
+				}
+				continue
+			}
+
+			yamlName = strings.ToLower(field.Name)
+		}
+
+		if fieldVal.Kind() == reflect.Struct {
+			names = append(names, unsupportedFieldNames(fieldVal, prefix+yamlName+".")...)
+		} else {
+			names = append(names, prefix+yamlName)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// compose expands "8000-8010:8000-8010" to individual ports. this collapses them back to a range
+func appendPortCollapsingRange(ports []Port, port Port) []Port {
+	if len(ports) > 0 {
+		previous := &ports[len(ports)-1]
+
+		count := uint32(1)
+		if previous.Count != nil {
+			count = *previous.Count
+		}
+
+		if previous.Mode == port.Mode && previous.Public+count == port.Public && previous.Container+count == port.Container {
+			count++
+			previous.Count = &count
+			return ports
+		}
+	}
+
+	return append(ports, port)
+}
@@ -0,0 +1,48 @@
+package servicespec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestComposeToSpec(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/composeToSpec.txt")
+	assert.Assert(t, err == nil)
+
+	parts := strings.Split(string(buf), "\n-------------\n")
+	assert.Assert(t, len(parts) == 3)
+
+	specContent, unsupported, err := ComposeToSpec([]byte(parts[0]))
+	assert.Assert(t, err == nil)
+
+	assert.EqualString(t, string(specContent), parts[1])
+	assert.EqualString(t, strings.Join(unsupported, "\n"), parts[2])
+
+	// generated spec must be in canonical layout
+	formatted, err := FormatSpec(specContent, "composeToSpec.hcl")
+	assert.Assert(t, err == nil)
+	assert.EqualString(t, string(formatted), string(specContent))
+
+	// generated spec must be valid, and also convert back to compose
+	_, diags := validateSpec(specContent, "composeToSpec.hcl", "testdata/nonexistent.vars", "testdata", testClusterContext)
+	assert.Assert(t, len(diags) == 0)
+
+	_, _, err = specToCompose(bytes.NewBuffer(specContent), "testdata/nonexistent.vars", "testdata", testClusterContext, nil)
+	assert.Assert(t, err == nil)
+}
+
+func TestComposeToSpecInvalidCompose(t *testing.T) {
+	_, _, err := ComposeToSpec([]byte(`version: "3.5"
+services:
+  web:
+    image: nginx
+    deploy:
+      replicas: many
+`))
+
+	assert.EqualString(t, err.Error(), "services.web.deploy.replicas must be a integer")
+}
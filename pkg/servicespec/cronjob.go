@@ -0,0 +1,111 @@
+package servicespec
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	composetypes "github.com/docker/cli/cli/compose/types"
+)
+
+// run on schedule by swarm-cronjob (https://github.com/crazy-max/swarm-cronjob), which scales
+// the service from 0 to 1 replicas on each tick
+func convertCronJob(job CronJob, compose *composetypes.Config, convCtx conversionContext) ([]FileObject, error) {
+	if problems := checkCronJob(job); len(problems) > 0 {
+		return nil, fmt.Errorf("cron_job %s: %s", job.Name, problems[0].message)
+	}
+
+	timeout, err := parseOptionalDuration(job.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("cron_job %s: timeout: %w", job.Name, err)
+	}
+
+	fileObjects, err := convertOneService(cronJobService(job), false, compose, convCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	composeService := &compose.Services[len(compose.Services)-1]
+
+	// swarm-cronjob only reads service labels. copy because container labels share the same map
+	deployLabels := composetypes.Labels{}
+	for key, value := range composeService.Deploy.Labels {
+		deployLabels[key] = value
+	}
+
+	deployLabels["swarm.cronjob.enable"] = "true"
+	deployLabels["swarm.cronjob.schedule"] = job.Schedule
+	deployLabels["swarm.cronjob.skip-running"] = strconv.FormatBool(job.SkipRunning)
+
+	composeService.Deploy.Labels = deployLabels
+
+	// swarm-cronjob has no timeouts. timeout(1) exists in coreutils and busybox, but it has to be
+	// in the image. it replaces the image's ENTRYPOINT, so command has to be the full command line
+	if timeout != nil {
+		composeService.Entrypoint = composetypes.ShellCommand{"timeout", timeoutSeconds(*timeout)}
+	}
+
+	zero := uint64(0) // not started on deploy, only on schedule
+	composeService.Deploy.Replicas = &zero
+
+	// exiting is the expected outcome of a job
+	composeService.Deploy.RestartPolicy = &composetypes.RestartPolicy{
+		Condition: "none",
+	}
+
+	return fileObjects, nil
+}
+
+func cronJobService(job CronJob) ServiceSpec {
+	service := job.ServiceSpec
+	service.Name = job.Name
+
+	return service
+}
+
+// "1500ms" => "2" (rounded up, as "0" would disable the timeout)
+func timeoutSeconds(timeout time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(timeout.Seconds())))
+}
+
+// on top of checkService()
+func checkCronJob(job CronJob) []specProblem {
+	problems := []specProblem{}
+
+	if err := validateCronSchedule(job.Schedule); err != nil {
+		problems = append(problems, specProblem{
+			subject: "schedule",
+			message: err.Error()})
+	}
+
+	if job.Replicas != nil {
+		problems = append(problems, specProblem{
+			subject: "replicas",
+			message: "replicas cannot be defined (swarm-cronjob starts the replica)"})
+	}
+
+	if job.RestartPolicy != nil {
+		problems = append(problems, specProblem{
+			subject: "restart_policy",
+			message: "restart_policy cannot be defined (jobs are not restarted)"})
+	}
+
+	if timeout, err := parseOptionalDuration(job.Timeout); err != nil {
+		problems = append(problems, specProblem{
+			subject: "timeout",
+			message: "timeout: " + err.Error()})
+	} else if timeout != nil && *timeout < time.Second {
+		problems = append(problems, specProblem{
+			subject: "timeout",
+			message: "timeout must be at least 1s"})
+	}
+
+	if job.Timeout != "" && len(job.Command) == 0 {
+		problems = append(problems, specProblem{
+			subject: "timeout",
+			message: "timeout requires command to be defined (image's entrypoint is replaced with timeout)"})
+	}
+
+	return problems
+}
@@ -0,0 +1,307 @@
+package servicespec
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// blocks of these types are sorted by their (first) label
+var formatSortedBlockTypes = map[string]bool{
+	"service":        true,
+	"global_service": true,
+	"cron_job":       true,
+	"env":            true,
+}
+
+// Rewrites spec into canonical form, so diffs between specs only show meaningful changes:
+//
+// - attributes first, in order of the struct fields (SpecFile, ServiceSpec etc.), then blocks
+// - blocks grouped by type in struct field order. services and env blocks sorted by name
+// - comments travel with the item they precede
+// - whitespace as in hclwrite.Format()
+//
+// attributes and blocks unknown to the spec format keep their relative order at the end.
+func FormatSpec(content []byte, filename string) ([]byte, error) {
+	file, diags := hclsyntax.ParseConfig(content, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	body := file.Body.(*hclsyntax.Body)
+
+	formatted := &bytes.Buffer{}
+	formatBody(content, body, 0, len(content), reflect.TypeOf(SpecFile{}), formatted)
+
+	return hclwrite.Format(formatted.Bytes()), nil
+}
+
+// source fragment of an attribute or block, along with its comments
+type formatItem struct {
+	name            string // attribute name or block type
+	label           string // blocks' first label
+	isBlock         bool
+	leadComments    []string
+	text            []byte
+	trailingComment string
+	closingComment  string // after block's closing brace
+	block           *hclsyntax.Block
+}
+
+// writes items of body (located between src[start:end]) in canonical order
+func formatBody(src []byte, body *hclsyntax.Body, start int, end int, typ reflect.Type, out *bytes.Buffer) {
+	items, trailingComments := formatItems(src, body, start, end)
+
+	order := hclFieldOrder(typ)
+
+	position := func(item formatItem) int {
+		if pos, found := order[item.name]; found {
+			return pos
+		}
+
+		return len(order) // unknown ones last
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+
+		if a.isBlock != b.isBlock {
+			return !a.isBlock
+		}
+
+		if position(a) != position(b) {
+			return position(a) < position(b)
+		}
+
+		if a.isBlock && a.name == b.name && formatSortedBlockTypes[a.name] {
+			return a.label < b.label
+		}
+
+		return false
+	})
+
+	hasContent := false
+
+	for _, item := range items {
+		// separate blocks from preceding content for readability
+		if item.isBlock && hasContent {
+			out.WriteString("\n")
+		}
+
+		for _, comment := range item.leadComments {
+			out.WriteString(comment + "\n")
+		}
+
+		if item.isBlock {
+			block := item.block
+
+			out.Write(src[block.TypeRange.Start.Byte:block.OpenBraceRange.End.Byte])
+			out.WriteString(item.trailingComment + "\n")
+
+			// comment after opening brace was already written
+			bodyStart := block.OpenBraceRange.End.Byte
+			if lineEnd, rest := restOfLine(src, bodyStart, block.CloseBraceRange.Start.Byte); isCommentOrEmpty(rest) {
+				bodyStart = lineEnd
+			}
+
+			formatBody(
+				src,
+				block.Body,
+				bodyStart,
+				block.CloseBraceRange.Start.Byte,
+				hclBlockType(typ, item.name),
+				out)
+
+			out.WriteString("}" + item.closingComment + "\n")
+		} else {
+			out.Write(item.text)
+			out.WriteString(item.trailingComment + "\n")
+		}
+
+		hasContent = true
+	}
+
+	for _, comment := range trailingComments {
+		out.WriteString(comment + "\n")
+	}
+}
+
+// returns also comments that are after the last item
+func formatItems(src []byte, body *hclsyntax.Body, start int, end int) ([]formatItem, []string) {
+	items := []formatItem{}
+
+	for _, attr := range body.Attributes {
+		items = append(items, formatItem{
+			name: attr.Name,
+			text: src[attr.SrcRange.Start.Byte:attr.SrcRange.End.Byte],
+		})
+	}
+
+	for _, block := range body.Blocks {
+		label := ""
+		if len(block.Labels) > 0 {
+			label = block.Labels[0]
+		}
+
+		items = append(items, formatItem{
+			name:    block.Type,
+			label:   label,
+			isBlock: true,
+			block:   block,
+		})
+	}
+
+	itemRange := func(item formatItem) (int, int) {
+		if item.isBlock {
+			return item.block.TypeRange.Start.Byte, item.block.CloseBraceRange.End.Byte
+		}
+
+		return body.Attributes[item.name].SrcRange.Start.Byte, body.Attributes[item.name].SrcRange.End.Byte
+	}
+
+	// attributes are in a map, so establish source order for assigning comments
+	sort.Slice(items, func(i, j int) bool {
+		iStart, _ := itemRange(items[i])
+		jStart, _ := itemRange(items[j])
+		return iStart < jStart
+	})
+
+	pos := start
+
+	for idx := range items {
+		itemStart, itemEnd := itemRange(items[idx])
+
+		items[idx].leadComments = commentLines(src[pos:itemStart])
+
+		// for blocks the trailing comment is the one after the opening brace
+		commentFrom := itemEnd
+		if items[idx].isBlock {
+			commentFrom = items[idx].block.OpenBraceRange.End.Byte
+		}
+
+		pos = itemEnd
+
+		lineEnd, rest := restOfLine(src, commentFrom, end)
+		if isCommentOrEmpty(rest) {
+			if trimmed := strings.TrimSpace(rest); trimmed != "" {
+				items[idx].trailingComment = " " + trimmed
+			}
+
+			if !items[idx].isBlock {
+				pos = lineEnd
+			}
+		}
+
+		if items[idx].isBlock {
+			if lineEnd, rest := restOfLine(src, itemEnd, end); isCommentOrEmpty(rest) {
+				if trimmed := strings.TrimSpace(rest); trimmed != "" {
+					items[idx].closingComment = " " + trimmed
+				}
+
+				pos = lineEnd
+			}
+		}
+	}
+
+	return items, commentLines(src[pos:end])
+}
+
+// returns position after the line's newline (or end) and the content before it
+func restOfLine(src []byte, pos int, end int) (int, string) {
+	newlinePos := bytes.IndexByte(src[pos:end], '\n')
+	if newlinePos == -1 {
+		return end, string(src[pos:end])
+	}
+
+	return pos + newlinePos + 1, string(src[pos : pos+newlinePos])
+}
+
+func isCommentOrEmpty(text string) bool {
+	trimmed := strings.TrimSpace(text)
+
+	return trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "/*")
+}
+
+// non-empty lines, trimmed (hclwrite.Format() re-indents them)
+func commentLines(region []byte) []string {
+	lines := []string{}
+	for _, line := range strings.Split(string(region), "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			lines = append(lines, trimmed)
+		}
+	}
+
+	return lines
+}
+
+// attribute/block name => position in struct (fields of embedded ",remain" structs included).
+// nil type (block unknown to spec format) gives an empty order
+func hclFieldOrder(typ reflect.Type) map[string]int {
+	order := map[string]int{}
+	if typ == nil {
+		return order
+	}
+
+	for _, field := range hclFields(typ) {
+		name, _ := parseHclTag(field.Tag.Get("hcl"))
+		if _, found := order[name]; !found {
+			order[name] = len(order)
+		}
+	}
+
+	return order
+}
+
+// struct type of block field, or nil if not known
+func hclBlockType(typ reflect.Type, blockType string) reflect.Type {
+	if typ == nil {
+		return nil
+	}
+
+	for _, field := range hclFields(typ) {
+		name, kind := parseHclTag(field.Tag.Get("hcl"))
+		if kind != "block" || name != blockType {
+			continue
+		}
+
+		elemType := field.Type
+		for elemType.Kind() == reflect.Ptr || elemType.Kind() == reflect.Slice {
+			elemType = elemType.Elem()
+		}
+
+		return elemType
+	}
+
+	return nil
+}
+
+// attribute and block fields, flattening embedded structs. template body is a partial service
+func hclFields(typ reflect.Type) []reflect.StructField {
+	fields := []reflect.StructField{}
+
+	if typ == reflect.TypeOf(Template{}) {
+		typ = reflect.TypeOf(ServiceSpec{})
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		_, kind := parseHclTag(field.Tag.Get("hcl"))
+
+		switch {
+		case kind == "remain" && field.Type.Kind() == reflect.Struct:
+			fields = append(fields, hclFields(field.Type)...)
+		case kind == "" || kind == "label" || kind == "remain":
+			continue
+		default:
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
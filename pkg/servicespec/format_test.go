@@ -0,0 +1,25 @@
+package servicespec
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestFormatSpec(t *testing.T) {
+	test := caseFromFile("format")
+
+	formatted, err := FormatSpec([]byte(test.input), "format.hcl")
+	assert.Assert(t, err == nil)
+	assert.EqualString(t, string(formatted), test.expectedOutput)
+
+	// already canonical form must not change
+	formattedAgain, err := FormatSpec(formatted, "format.hcl")
+	assert.Assert(t, err == nil)
+	assert.EqualString(t, string(formattedAgain), string(formatted))
+}
+
+func TestFormatSpecSyntaxError(t *testing.T) {
+	_, err := FormatSpec([]byte(`service "foo" {`), "format.hcl")
+	assert.Assert(t, err != nil)
+}
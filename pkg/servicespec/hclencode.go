@@ -0,0 +1,166 @@
+package servicespec
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+var hclExpressionType = reflect.TypeOf((*hcl.Expression)(nil)).Elem()
+
+// writes a struct with "hcl" tags (like SpecFile) as HCL. gohcl's encoder writes out every
+// optional attribute, which would make generated specs noisy, so we omit zero-valued ones.
+func encodeHcl(val interface{}) ([]byte, error) {
+	file := hclwrite.NewEmptyFile()
+
+	if _, err := encodeHclBody(reflect.ValueOf(val), file.Body(), false); err != nil {
+		return nil, err
+	}
+
+	return hclwrite.Format(file.Bytes()), nil
+}
+
+// returns whether body has content (used for separating blocks with an empty line)
+func encodeHclBody(val reflect.Value, body *hclwrite.Body, hasContent bool) (bool, error) {
+	for val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+
+	typ := val.Type()
+
+	lastWasBlock := false
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		fieldVal := val.Field(i)
+
+		name, kind := parseHclTag(field.Tag.Get("hcl"))
+
+		switch kind {
+		case "":
+			continue // not a HCL field
+		case "label":
+			continue // written by the parent
+		case "remain":
+			var err error
+			hasContent, err = encodeHclBody(fieldVal, body, hasContent)
+			if err != nil {
+				return false, err
+			}
+		case "block":
+			for _, blockVal := range hclBlockValues(fieldVal) {
+				// separate blocks from preceding content for readability
+				if hasContent {
+					body.AppendNewline()
+				}
+
+				block := body.AppendNewBlock(name, hclLabels(blockVal))
+				if _, err := encodeHclBody(blockVal, block.Body(), false); err != nil {
+					return false, err
+				}
+
+				hasContent = true
+				lastWasBlock = true
+			}
+		default: // attribute
+			if fieldVal.Type() == hclExpressionType {
+				continue // unevaluated expressions cannot be written back
+			}
+
+			isOptional := kind == "optional" || fieldVal.Kind() == reflect.Ptr
+			if isOptional && isZeroValue(fieldVal) {
+				continue
+			}
+
+			value, err := hclAttributeValue(fieldVal)
+			if err != nil {
+				return false, fmt.Errorf("%s: %w", name, err)
+			}
+
+			if lastWasBlock {
+				body.AppendNewline()
+			}
+
+			body.SetAttributeValue(name, value)
+
+			hasContent = true
+			lastWasBlock = false
+		}
+	}
+
+	return hasContent, nil
+}
+
+func hclAttributeValue(fieldVal reflect.Value) (cty.Value, error) {
+	// float32 => float64 conversion would turn 0.1 into 0.10000000149011612
+	if fieldVal.Kind() == reflect.Float32 {
+		return cty.ParseNumberVal(strconv.FormatFloat(fieldVal.Float(), 'f', -1, 32))
+	}
+
+	ty, err := gocty.ImpliedType(fieldVal.Interface())
+	if err != nil {
+		return cty.NilVal, err
+	}
+
+	return gocty.ToCtyValue(fieldVal.Interface(), ty)
+}
+
+// block fields can be slices, pointers or plain structs
+func hclBlockValues(fieldVal reflect.Value) []reflect.Value {
+	switch fieldVal.Kind() {
+	case reflect.Slice:
+		values := []reflect.Value{}
+		for i := 0; i < fieldVal.Len(); i++ {
+			values = append(values, fieldVal.Index(i))
+		}
+		return values
+	case reflect.Ptr:
+		if fieldVal.IsNil() {
+			return nil
+		}
+		return []reflect.Value{fieldVal.Elem()}
+	default:
+		return []reflect.Value{fieldVal}
+	}
+}
+
+func hclLabels(blockVal reflect.Value) []string {
+	labels := []string{}
+
+	for i := 0; i < blockVal.NumField(); i++ {
+		if _, kind := parseHclTag(blockVal.Type().Field(i).Tag.Get("hcl")); kind == "label" {
+			labels = append(labels, blockVal.Field(i).String())
+		}
+	}
+
+	return labels
+}
+
+// `hcl:"name,block"` => ("name", "block"). attributes without a kind are "attr"
+func parseHclTag(tag string) (string, string) {
+	if tag == "" {
+		return "", ""
+	}
+
+	parts := strings.Split(tag, ",")
+	if len(parts) == 1 {
+		return parts[0], "attr"
+	}
+
+	return parts[0], parts[1]
+}
+
+func isZeroValue(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Slice, reflect.Map:
+		return val.Len() == 0
+	default:
+		return val.IsZero()
+	}
+}
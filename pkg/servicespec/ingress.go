@@ -0,0 +1,301 @@
+package servicespec
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	composetypes "github.com/docker/cli/cli/compose/types"
+)
+
+// which label format the cluster's edge router understands
+type IngressDialect string
+
+const (
+	IngressDialectTraefikV1 IngressDialect = "traefik_v1" // also the default
+	IngressDialectTraefikV2 IngressDialect = "traefik_v2"
+)
+
+// auth modes of the named ingress blocks. these are also the values of the edgerouter.auth label
+const (
+	ingressAuthPublic      = "public"
+	ingressAuthBearerToken = "bearer_token"
+	ingressAuthSso         = "sso"
+)
+
+// one routable endpoint of a service. name is empty for the (legacy) unnamed ingress_* blocks
+type ingressRoute struct {
+	name     string
+	settings SharedIngressSettings
+	auth     map[string]string // Edgerouter's auth labels without the "edgerouter." prefix
+}
+
+// auth mode validity and maximum of one unnamed ingress are validated by checkService()
+func serviceIngresses(service ServiceSpec) []ingressRoute {
+	routes := []ingressRoute{}
+
+	switch {
+	case service.IngressPublic != nil:
+		routes = append(routes, publicIngressRoute("", service.IngressPublic.SharedIngressSettings))
+	case service.IngressBearer != nil:
+		routes = append(routes, bearerIngressRoute(
+			"",
+			service.IngressBearer.SharedIngressSettings,
+			service.IngressBearer.Token))
+	case service.IngressSso != nil:
+		routes = append(routes, ssoIngressRoute(
+			"",
+			service.IngressSso.SharedIngressSettings,
+			service.IngressSso.Tenant,
+			service.IngressSso.Users))
+	}
+
+	for _, ingress := range service.Ingresses {
+		switch ingress.Auth {
+		case ingressAuthPublic:
+			routes = append(routes, publicIngressRoute(ingress.Name, ingress.SharedIngressSettings))
+		case ingressAuthBearerToken:
+			routes = append(routes, bearerIngressRoute(ingress.Name, ingress.SharedIngressSettings, ingress.Token))
+		case ingressAuthSso:
+			routes = append(routes, ssoIngressRoute(ingress.Name, ingress.SharedIngressSettings, ingress.Tenant, ingress.Users))
+		}
+	}
+
+	return routes
+}
+
+// requires explicit opt-in, so a key missing does not accidentally expose endpoints to public
+func publicIngressRoute(name string, settings SharedIngressSettings) ingressRoute {
+	return ingressRoute{name, settings, map[string]string{
+		"auth": ingressAuthPublic,
+	}}
+}
+
+func bearerIngressRoute(name string, settings SharedIngressSettings, token string) ingressRoute {
+	return ingressRoute{name, settings, map[string]string{
+		"auth":              ingressAuthBearerToken,
+		"auth_bearer_token": token,
+	}}
+}
+
+func ssoIngressRoute(name string, settings SharedIngressSettings, tenant string, users []string) ingressRoute {
+	return ingressRoute{name, settings, map[string]string{
+		"auth":            ingressAuthSso,
+		"auth_sso.tenant": tenant,
+		"auth_sso.users":  strings.Join(users, ","),
+	}}
+}
+
+// unnamed: "edgerouter.auth". named: "edgerouter.<name>.auth" (like Traefik v1's segment labels)
+func (r ingressRoute) authLabels() map[string]string {
+	prefix := "edgerouter."
+	if r.name != "" {
+		prefix += r.name + "."
+	}
+
+	labels := map[string]string{}
+	for key, value := range r.auth {
+		labels[prefix+key] = value
+	}
+
+	return labels
+}
+
+func addIngressRoutingLabels(
+	labels composetypes.Labels,
+	stackName string,
+	serviceName string,
+	route ingressRoute,
+	dialect IngressDialect,
+) error {
+	switch dialect {
+	case "", IngressDialectTraefikV1:
+		// named routes use segment labels, so each gets its own frontend and backend
+		prefix := "traefik."
+		if route.name != "" {
+			prefix += route.name + "."
+		}
+
+		labels[prefix+"frontend.rule"] = route.settings.Rule
+		if route.settings.Port != nil {
+			labels[prefix+"port"] = strconv.Itoa(*route.settings.Port)
+		}
+
+		return nil
+	case IngressDialectTraefikV2:
+		// names are global to Traefik, so they're namespaced by stack
+		nameParts := []string{stackName, serviceName}
+		if route.name != "" {
+			nameParts = append(nameParts, route.name)
+		}
+
+		return addTraefikV2Labels(labels, traefikV2Name(nameParts...), route.settings)
+	default:
+		return fmt.Errorf("unsupported ingress dialect: %s", dialect)
+	}
+}
+
+// two routes of a service matching the same requests would make routing ambiguous. rules are
+// compared by their matchers, so "Host:a.com,b.com" and "Host: b.com, a.com" collide
+func ingressRuleKey(rule string) string {
+	matchers, err := parseTraefikV1Rule(rule)
+	if err != nil { // reported by conversion
+		return rule
+	}
+
+	parts := []string{}
+	for _, matcher := range matchers {
+		values := append([]string{}, matcher.values...)
+		sort.Strings(values)
+
+		parts = append(parts, matcher.kind+":"+strings.Join(values, ","))
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, ";")
+}
+
+func addTraefikV2Labels(labels composetypes.Labels, name string, ingress SharedIngressSettings) error {
+	rule, middlewares, err := traefikV1RuleToV2(ingress.Rule)
+	if err != nil {
+		return err
+	}
+
+	router := "traefik.http.routers." + name
+
+	labels[router+".rule"] = rule
+
+	if ingress.Port != nil {
+		labels[router+".service"] = name
+		labels["traefik.http.services."+name+".loadbalancer.server.port"] = strconv.Itoa(*ingress.Port)
+	}
+
+	middlewareNames := []string{}
+	for _, middleware := range middlewares {
+		middlewareName := name + traefikV2NameSeparator + middleware.kind
+		middlewareNames = append(middlewareNames, middlewareName)
+
+		labels["traefik.http.middlewares."+middlewareName+"."+middleware.kind+"."+middleware.option] = middleware.value
+	}
+
+	if len(middlewareNames) > 0 {
+		labels[router+".middlewares"] = strings.Join(middlewareNames, ",")
+	}
+
+	return nil
+}
+
+// v1 rules could modify requests, which in v2 is done by middlewares
+type traefikV2Middleware struct {
+	kind   string // "stripprefix"
+	option string // "prefixes"
+	value  string // "/api"
+}
+
+type traefikV1Matcher struct {
+	kind   string   // "Host"
+	values []string // ["example.com", "www.example.com"]
+}
+
+// "Host:example.com,www.example.com;PathPrefixStrip:/api"
+func parseTraefikV1Rule(rule string) ([]traefikV1Matcher, error) {
+	matchers := []traefikV1Matcher{}
+
+	for _, part := range strings.Split(rule, ";") {
+		colonPos := strings.Index(part, ":")
+		if colonPos == -1 {
+			return nil, fmt.Errorf("invalid Traefik v1 rule: %s", rule)
+		}
+
+		matchers = append(matchers, traefikV1Matcher{
+			kind:   strings.TrimSpace(part[0:colonPos]),
+			values: splitAndTrim(part[colonPos+1:]),
+		})
+	}
+
+	return matchers, nil
+}
+
+// "Host:example.com,www.example.com;PathPrefixStrip:/api" =>
+// "Host(`example.com`, `www.example.com`) && PathPrefix(`/api`)" + stripprefix middleware
+func traefikV1RuleToV2(v1Rule string) (string, []traefikV2Middleware, error) {
+	v1Matchers, err := parseTraefikV1Rule(v1Rule)
+	if err != nil {
+		return "", nil, err
+	}
+
+	matchers := []string{}
+	middlewares := []traefikV2Middleware{}
+
+	for _, matcher := range v1Matchers {
+		kind, values := matcher.kind, matcher.values
+
+		switch kind {
+		case "Host", "HostRegexp", "Path", "PathPrefix", "Method", "Headers", "HeadersRegexp", "Query":
+			matchers = append(matchers, traefikV2Matcher(kind, values))
+		case "PathStrip", "PathPrefixStrip":
+			matchers = append(matchers, traefikV2Matcher(strings.TrimSuffix(kind, "Strip"), values))
+
+			middlewares = append(middlewares, traefikV2Middleware{
+				kind:   "stripprefix",
+				option: "prefixes",
+				value:  strings.Join(values, ","),
+			})
+		case "AddPrefix":
+			middlewares = append(middlewares, traefikV2Middleware{
+				kind:   "addprefix",
+				option: "prefix",
+				value:  strings.Join(values, ","),
+			})
+		default:
+			return "", nil, fmt.Errorf("unsupported Traefik v1 rule type for v2 translation: %s", kind)
+		}
+	}
+
+	if len(matchers) == 0 {
+		return "", nil, fmt.Errorf("no matchers in rule: %s", v1Rule)
+	}
+
+	return strings.Join(matchers, " && "), middlewares, nil
+}
+
+// ("Host", ["a.com", "b.com"]) => "Host(`a.com`, `b.com`)"
+func traefikV2Matcher(kind string, values []string) string {
+	quoted := []string{}
+	for _, value := range values {
+		quoted = append(quoted, "`"+value+"`")
+	}
+
+	return kind + "(" + strings.Join(quoted, ", ") + ")"
+}
+
+var traefikV2NameInvalidChars = regexp.MustCompile("[^a-zA-Z0-9-]")
+
+// never produced by sanitizing a part, so joined names are unambiguous
+const traefikV2NameSeparator = "_"
+
+// router/service/middleware names are used as parts of label keys (dot-separated).
+// ("hellohttp", "web", "admin") => "hellohttp_web_admin"
+func traefikV2Name(parts ...string) string {
+	sanitized := []string{}
+	for _, part := range parts {
+		if part == "" { // stack name is not known when converting without a james ref
+			continue
+		}
+
+		sanitized = append(sanitized, traefikV2NameInvalidChars.ReplaceAllString(part, "-"))
+	}
+
+	return strings.Join(sanitized, traefikV2NameSeparator)
+}
+
+func splitAndTrim(input string) []string {
+	items := []string{}
+	for _, item := range strings.Split(input, ",") {
+		items = append(items, strings.TrimSpace(item))
+	}
+
+	return items
+}
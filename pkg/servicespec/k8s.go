@@ -0,0 +1,1035 @@
+package servicespec
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-yaml/yaml"
+)
+
+// specs don't say how large persistent volumes should be, but PersistentVolumeClaims require it
+const k8sDefaultVolumeSize = "1Gi"
+
+// subset of Kubernetes' object model that we generate. we don't use k8s.io/api because it
+// would be a huge dependency for just rendering YAML
+type k8sObject struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`       // Secret
+	Data       map[string]string `yaml:"data,omitempty"`       // Secret (base64) | ConfigMap
+	BinaryData map[string]string `yaml:"binaryData,omitempty"` // ConfigMap
+	Spec       interface{}       `yaml:"spec,omitempty"`
+}
+
+type k8sMetadata struct {
+	Name        string            `yaml:"name,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Deployment | DaemonSet
+type k8sWorkloadSpec struct {
+	Replicas       *uint64              `yaml:"replicas,omitempty"`
+	Selector       k8sLabelSelector     `yaml:"selector"`
+	Strategy       *k8sWorkloadStrategy `yaml:"strategy,omitempty"`       // Deployment
+	UpdateStrategy *k8sWorkloadStrategy `yaml:"updateStrategy,omitempty"` // DaemonSet
+	Template       k8sPodTemplateSpec   `yaml:"template"`
+}
+
+type k8sLabelSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type k8sWorkloadStrategy struct {
+	Type          string            `yaml:"type"`
+	RollingUpdate *k8sRollingUpdate `yaml:"rollingUpdate,omitempty"`
+}
+
+// values are ints or percentages
+type k8sRollingUpdate struct {
+	MaxSurge       interface{} `yaml:"maxSurge,omitempty"`
+	MaxUnavailable interface{} `yaml:"maxUnavailable"`
+}
+
+type k8sPodTemplateSpec struct {
+	Metadata k8sMetadata `yaml:"metadata"`
+	Spec     k8sPodSpec  `yaml:"spec"`
+}
+
+type k8sPodSpec struct {
+	Containers                    []k8sContainer    `yaml:"containers"`
+	Volumes                       []k8sVolume       `yaml:"volumes,omitempty"`
+	NodeSelector                  map[string]string `yaml:"nodeSelector,omitempty"`
+	HostNetwork                   bool              `yaml:"hostNetwork,omitempty"`
+	HostPID                       bool              `yaml:"hostPID,omitempty"`
+	HostAliases                   []k8sHostAlias    `yaml:"hostAliases,omitempty"`
+	TerminationGracePeriodSeconds *int64            `yaml:"terminationGracePeriodSeconds,omitempty"`
+}
+
+type k8sHostAlias struct {
+	IP        string   `yaml:"ip"`
+	Hostnames []string `yaml:"hostnames"`
+}
+
+type k8sContainer struct {
+	Name            string              `yaml:"name"`
+	Image           string              `yaml:"image"`
+	Args            []string            `yaml:"args,omitempty"`
+	Env             []k8sEnvVar         `yaml:"env,omitempty"`
+	Ports           []k8sContainerPort  `yaml:"ports,omitempty"`
+	Resources       k8sResources        `yaml:"resources"`
+	SecurityContext *k8sSecurityContext `yaml:"securityContext,omitempty"`
+	VolumeMounts    []k8sVolumeMount    `yaml:"volumeMounts,omitempty"`
+	ReadinessProbe  *k8sProbe           `yaml:"readinessProbe,omitempty"`
+}
+
+type k8sEnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type k8sContainerPort struct {
+	ContainerPort uint32 `yaml:"containerPort"`
+	HostPort      uint32 `yaml:"hostPort,omitempty"`
+	Protocol      string `yaml:"protocol"`
+}
+
+type k8sResources struct {
+	Limits   map[string]string `yaml:"limits,omitempty"`
+	Requests map[string]string `yaml:"requests,omitempty"`
+}
+
+type k8sSecurityContext struct {
+	Privileged   bool             `yaml:"privileged,omitempty"`
+	RunAsUser    *int64           `yaml:"runAsUser,omitempty"`
+	RunAsGroup   *int64           `yaml:"runAsGroup,omitempty"`
+	Capabilities *k8sCapabilities `yaml:"capabilities,omitempty"`
+}
+
+type k8sCapabilities struct {
+	Add []string `yaml:"add"`
+}
+
+type k8sProbe struct {
+	Exec                k8sExecAction `yaml:"exec"`
+	InitialDelaySeconds int64         `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int64         `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds      int64         `yaml:"timeoutSeconds,omitempty"`
+	FailureThreshold    uint64        `yaml:"failureThreshold,omitempty"`
+}
+
+type k8sExecAction struct {
+	Command []string `yaml:"command"`
+}
+
+type k8sVolume struct {
+	Name                  string                    `yaml:"name"`
+	PersistentVolumeClaim *k8sPersistentVolumeClaim `yaml:"persistentVolumeClaim,omitempty"`
+	HostPath              *k8sHostPath              `yaml:"hostPath,omitempty"`
+	Secret                *k8sSecretVolume          `yaml:"secret,omitempty"`
+	ConfigMap             *k8sConfigMapVolume       `yaml:"configMap,omitempty"`
+	EmptyDir              *k8sEmptyDir              `yaml:"emptyDir,omitempty"`
+}
+
+type k8sEmptyDir struct {
+	Medium    string `yaml:"medium,omitempty"`
+	SizeLimit string `yaml:"sizeLimit,omitempty"`
+}
+
+type k8sPersistentVolumeClaim struct {
+	ClaimName string `yaml:"claimName"`
+}
+
+type k8sHostPath struct {
+	Path string `yaml:"path"`
+}
+
+type k8sSecretVolume struct {
+	SecretName string `yaml:"secretName"`
+}
+
+type k8sConfigMapVolume struct {
+	Name string `yaml:"name"`
+}
+
+type k8sVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	SubPath   string `yaml:"subPath,omitempty"`
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+type k8sServiceSpec struct {
+	Type     string            `yaml:"type,omitempty"`
+	Selector map[string]string `yaml:"selector"`
+	Ports    []k8sServicePort  `yaml:"ports"`
+}
+
+type k8sServicePort struct {
+	Name       string `yaml:"name"`
+	Protocol   string `yaml:"protocol"`
+	Port       uint32 `yaml:"port"`
+	TargetPort uint32 `yaml:"targetPort"`
+}
+
+type k8sPersistentVolumeClaimSpec struct {
+	AccessModes []string     `yaml:"accessModes"`
+	Resources   k8sResources `yaml:"resources"`
+}
+
+type k8sIngressSpec struct {
+	Rules []k8sIngressRule `yaml:"rules"`
+}
+
+type k8sIngressRule struct {
+	Host string             `yaml:"host,omitempty"`
+	HTTP k8sIngressRuleHTTP `yaml:"http"`
+}
+
+type k8sIngressRuleHTTP struct {
+	Paths []k8sIngressPath `yaml:"paths"`
+}
+
+type k8sIngressPath struct {
+	Path     string            `yaml:"path"`
+	PathType string            `yaml:"pathType"`
+	Backend  k8sIngressBackend `yaml:"backend"`
+}
+
+type k8sIngressBackend struct {
+	Service k8sIngressServiceBackend `yaml:"service"`
+}
+
+type k8sIngressServiceBackend struct {
+	Name string                `yaml:"name"`
+	Port k8sServiceBackendPort `yaml:"port"`
+}
+
+type k8sServiceBackendPort struct {
+	Number int `yaml:"number"`
+}
+
+// state shared by all services of a spec file
+type k8sConversion struct {
+	conversionContext
+	objects       []k8sObject
+	createdClaims map[string]bool // services can share persistent volumes
+	fileObjects   []FileObject
+}
+
+// returns multi-document YAML with Deployments (DaemonSets for global services), Services,
+// PersistentVolumeClaims, Ingresses, Secrets and ConfigMaps
+func SpecToK8sByPath(path string, clusterCtx ClusterContext) (string, error) {
+	specFile, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer specFile.Close()
+
+	return specToK8s(
+		specFile,
+		VarOverridesPath(path, clusterCtx.ID),
+		filepath.Dir(path),
+		clusterCtx)
+}
+
+func specToK8s(
+	content io.Reader,
+	varOverridesPath string,
+	specDir string,
+	clusterCtx ClusterContext,
+) (string, error) {
+	spec, err := parseSpecFile(content, varOverridesPath, specDir, clusterCtx)
+	if err != nil {
+		return "", err
+	}
+
+	objects, err := specToK8sObjects(spec, specDir, clusterCtx)
+	if err != nil {
+		return "", err
+	}
+
+	documents := []string{}
+	for _, object := range objects {
+		yamlBytes, err := yaml.Marshal(&object)
+		if err != nil {
+			return "", err
+		}
+
+		documents = append(documents, string(yamlBytes))
+	}
+
+	return strings.Join(documents, "---\n"), nil
+}
+
+func specToK8sObjects(spec *SpecFile, specDir string, clusterCtx ClusterContext) ([]k8sObject, error) {
+	for _, strategy := range spec.UpdateStrategies {
+		// Kubernetes doesn't roll back failed rollouts on its own ($ kubectl rollout undo does)
+		if strategy.Rollback != nil {
+			return nil, fmt.Errorf("update_strategy %s: rollback_config not supported for Kubernetes", strategy.Name)
+		}
+	}
+
+	updateStrategies, err := resolveUpdateStrategies(spec.UpdateStrategies)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := &k8sConversion{
+		conversionContext: conversionContext{
+			specDir:          specDir,
+			updateStrategies: updateStrategies,
+			cluster:          clusterCtx,
+		},
+		objects:       []k8sObject{},
+		createdClaims: map[string]bool{},
+		fileObjects:   []FileObject{},
+	}
+
+	for _, service := range spec.Services {
+		if err := conv.convertService(service, false); err != nil {
+			return nil, fmt.Errorf("service %s: %w", service.Name, err)
+		}
+	}
+
+	for _, service := range spec.GlobalServices {
+		if err := conv.convertService(service, true); err != nil {
+			return nil, fmt.Errorf("global_service %s: %w", service.Name, err)
+		}
+	}
+
+	for _, job := range spec.CronJobs {
+		return nil, fmt.Errorf("cron_job %s: not supported for Kubernetes", job.Name)
+	}
+
+	return conv.objects, nil
+}
+
+// cluster's default labels and logging driver are Swarm-specific, so they don't apply here
+func (c *k8sConversion) convertService(service ServiceSpec, isGlobal bool) error {
+	service = withDefaults(service, c.cluster.Defaults)
+
+	if problems := checkService(service, isGlobal, c.updateStrategies); len(problems) > 0 {
+		return errors.New(problems[0].message)
+	}
+
+	if err := checkK8sUnsupported(service); err != nil {
+		return err
+	}
+
+	// validation is shared with the compose backend
+	if _, err := convertResources(service); err != nil {
+		return err
+	}
+
+	name := k8sName(service.Name)
+
+	selector := map[string]string{
+		"app.kubernetes.io/name": name,
+	}
+
+	container := k8sContainer{
+		Name:  name,
+		Image: service.Image + ":" + service.Version,
+		Args:  service.Command, // Docker's "command" is Kubernetes' "args"
+		Env:   k8sEnvs(service),
+		Resources: k8sResources{
+			Limits: map[string]string{},
+		},
+	}
+
+	if *service.RamMb != 0 { // 0 = no limit
+		container.Resources.Limits["memory"] = fmt.Sprintf("%dMi", *service.RamMb)
+	}
+	if service.Cpus != nil {
+		container.Resources.Limits["cpu"] = formatCpus(*service.Cpus)
+	}
+	if service.RamReservationMb != nil || service.CpusReservation != nil {
+		container.Resources.Requests = map[string]string{}
+
+		if service.RamReservationMb != nil {
+			container.Resources.Requests["memory"] = fmt.Sprintf("%dMi", *service.RamReservationMb)
+		}
+		if service.CpusReservation != nil {
+			container.Resources.Requests["cpu"] = formatCpus(*service.CpusReservation)
+		}
+	}
+
+	securityContext, err := k8sSecurityContextFor(service)
+	if err != nil {
+		return err
+	}
+	container.SecurityContext = securityContext
+
+	for _, port := range k8sPorts(service) {
+		container.Ports = append(container.Ports, k8sContainerPort{
+			ContainerPort: port.TargetPort,
+			Protocol:      port.Protocol,
+		})
+	}
+
+	addHostPorts := func(specPorts []Port, protocol string) {
+		for _, port := range expandPortRanges(specPorts) {
+			if portMode(port) != portModeHost {
+				continue
+			}
+
+			container.Ports = append(container.Ports, k8sContainerPort{
+				ContainerPort: port.Container,
+				HostPort:      port.Public,
+				Protocol:      protocol,
+			})
+		}
+	}
+
+	addHostPorts(service.TcpPorts, "TCP")
+	addHostPorts(service.UdpPorts, "UDP")
+
+	podSpec := k8sPodSpec{
+		HostNetwork: service.NetHost,
+		HostPID:     service.PidHost,
+	}
+
+	if service.PlacementNodeHostname != "" {
+		podSpec.NodeSelector = map[string]string{
+			"kubernetes.io/hostname": service.PlacementNodeHostname,
+		}
+	}
+
+	if healthcheck := service.Healthcheck; healthcheck != nil {
+		container.ReadinessProbe, err = k8sReadinessProbe(*healthcheck)
+		if err != nil {
+			return err
+		}
+	}
+
+	// validation is shared with the compose backend
+	if _, err := convertExtraHosts(service.ExtraHosts); err != nil {
+		return err
+	}
+
+	for _, extraHost := range service.ExtraHosts {
+		podSpec.HostAliases = append(podSpec.HostAliases, k8sHostAlias{
+			IP:        extraHost.IP,
+			Hostnames: []string{extraHost.Hostname},
+		})
+	}
+
+	stopGracePeriod, err := parseOptionalDuration(service.StopGracePeriod)
+	if err != nil {
+		return fmt.Errorf("stop_grace_period: %w", err)
+	}
+	if stopGracePeriod != nil {
+		seconds := durationSeconds(*stopGracePeriod)
+		podSpec.TerminationGracePeriodSeconds = &seconds
+	}
+
+	if err := c.convertVolumes(service, &container, &podSpec); err != nil {
+		return err
+	}
+
+	podSpec.Containers = []k8sContainer{container}
+
+	podAnnotations := map[string]string{}
+	if backup := service.Backup; backup != nil {
+		backupLabels, err := backupLabels(*backup)
+		if err != nil {
+			return err
+		}
+
+		for key, value := range backupLabels {
+			podAnnotations[key] = value
+		}
+	}
+
+	workloadSpec := k8sWorkloadSpec{
+		Selector: k8sLabelSelector{MatchLabels: selector},
+		Template: k8sPodTemplateSpec{
+			Metadata: k8sMetadata{
+				Labels:      selector,
+				Annotations: podAnnotations,
+			},
+			Spec: podSpec,
+		},
+	}
+
+	updateStrategy := c.updateStrategies[service.HowToUpdate]
+
+	kind := "Deployment"
+	if isGlobal {
+		kind = "DaemonSet"
+		workloadSpec.UpdateStrategy = k8sDaemonSetStrategy(updateStrategy)
+	} else {
+		workloadSpec.Replicas = service.Replicas
+		workloadSpec.Strategy = k8sDeploymentStrategy(updateStrategy)
+	}
+
+	c.objects = append(c.objects, k8sObject{
+		APIVersion: "apps/v1",
+		Kind:       kind,
+		Metadata:   c.metadata(name, selector),
+		Spec:       workloadSpec,
+	})
+
+	servicePorts := k8sPorts(service)
+	routes := serviceIngresses(service)
+
+	for _, route := range routes {
+		if route.settings.Port == nil {
+			return errors.New("ingress port is required for Kubernetes")
+		}
+
+		if !hasServicePort(servicePorts, uint32(*route.settings.Port)) {
+			portName := "http"
+			if route.name != "" {
+				portName += "-" + route.name
+			}
+
+			servicePorts = append(servicePorts, k8sServicePort{
+				Name:       portName,
+				Protocol:   "TCP",
+				Port:       uint32(*route.settings.Port),
+				TargetPort: uint32(*route.settings.Port),
+			})
+		}
+	}
+
+	if len(servicePorts) > 0 {
+		serviceType := "" // ClusterIP
+		if len(k8sPorts(service)) > 0 {
+			// closest to Swarm's routing mesh. k3s implements these by listening on each node
+			serviceType = "LoadBalancer"
+		}
+
+		c.objects = append(c.objects, k8sObject{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Metadata:   c.metadata(name, selector),
+			Spec: k8sServiceSpec{
+				Type:     serviceType,
+				Selector: selector,
+				Ports:    servicePorts,
+			},
+		})
+	}
+
+	// annotations are per-object, so each route gets its own Ingress
+	for _, route := range routes {
+		ingressObject, err := c.ingress(name, route, selector)
+		if err != nil {
+			return err
+		}
+
+		c.objects = append(c.objects, *ingressObject)
+	}
+
+	return nil
+}
+
+func (c *k8sConversion) convertVolumes(service ServiceSpec, container *k8sContainer, podSpec *k8sPodSpec) error {
+	addVolume := func(volume k8sVolume, mount k8sVolumeMount) {
+		podSpec.Volumes = append(podSpec.Volumes, volume)
+		container.VolumeMounts = append(container.VolumeMounts, mount)
+	}
+
+	for idx, bindMount := range service.BindMounts {
+		name := fmt.Sprintf("bindmount-%d", idx)
+
+		addVolume(k8sVolume{
+			Name:     name,
+			HostPath: &k8sHostPath{Path: bindMount.Host},
+		}, k8sVolumeMount{
+			Name:      name,
+			MountPath: bindMount.Container,
+			ReadOnly:  bindMount.ReadOnly,
+		})
+	}
+
+	for _, pv := range service.PersistentVolumes {
+		claimName := k8sName(pv.Name)
+
+		if !c.createdClaims[claimName] {
+			c.createdClaims[claimName] = true
+
+			c.objects = append(c.objects, k8sObject{
+				APIVersion: "v1",
+				Kind:       "PersistentVolumeClaim",
+				Metadata:   c.metadata(claimName, nil),
+				Spec: k8sPersistentVolumeClaimSpec{
+					AccessModes: []string{"ReadWriteOnce"},
+					Resources: k8sResources{
+						Requests: map[string]string{"storage": k8sDefaultVolumeSize},
+					},
+				},
+			})
+		}
+
+		addVolume(k8sVolume{
+			Name:                  claimName,
+			PersistentVolumeClaim: &k8sPersistentVolumeClaim{ClaimName: claimName},
+		}, k8sVolumeMount{
+			Name:      claimName,
+			MountPath: pv.Target,
+		})
+	}
+
+	for idx, tmpfs := range service.Tmpfs {
+		name := fmt.Sprintf("tmpfs-%d", idx)
+
+		emptyDir := &k8sEmptyDir{Medium: "Memory"}
+		if tmpfs.SizeMb != nil {
+			emptyDir.SizeLimit = fmt.Sprintf("%dMi", *tmpfs.SizeMb)
+		}
+
+		addVolume(k8sVolume{
+			Name:     name,
+			EmptyDir: emptyDir,
+		}, k8sVolumeMount{
+			Name:      name,
+			MountPath: tmpfs.Target,
+		})
+	}
+
+	// file objects get content hashed names like in Swarm, so changed content rolls out pods
+	for _, secret := range service.Secrets {
+		fileObject, err := readFileObject(FileObjectKindSecret, secret, c.specDir)
+		if err != nil {
+			return err
+		}
+
+		objectName := c.addFileObject(*fileObject)
+
+		addVolume(k8sVolume{
+			Name:   objectName,
+			Secret: &k8sSecretVolume{SecretName: objectName},
+		}, k8sVolumeMount{
+			Name:      objectName,
+			MountPath: k8sFileObjectTarget(FileObjectKindSecret, secret),
+			SubPath:   k8sFileObjectKey,
+			ReadOnly:  true,
+		})
+	}
+
+	for _, config := range service.Configs {
+		fileObject, err := readFileObject(FileObjectKindConfig, config, c.specDir)
+		if err != nil {
+			return err
+		}
+
+		objectName := c.addFileObject(*fileObject)
+
+		addVolume(k8sVolume{
+			Name:      objectName,
+			ConfigMap: &k8sConfigMapVolume{Name: objectName},
+		}, k8sVolumeMount{
+			Name:      objectName,
+			MountPath: k8sFileObjectTarget(FileObjectKindConfig, config),
+			SubPath:   k8sFileObjectKey,
+			ReadOnly:  true,
+		})
+	}
+
+	return nil
+}
+
+// secrets and configs are single files, so they're stored under one key
+const k8sFileObjectKey = "content"
+
+// returns name of the Secret or ConfigMap
+func (c *k8sConversion) addFileObject(fileObject FileObject) string {
+	name := k8sName(fileObject.Name)
+
+	for _, existing := range c.fileObjects {
+		if existing.Kind == fileObject.Kind && existing.Name == fileObject.Name {
+			return name // services can share these
+		}
+	}
+
+	c.fileObjects = append(c.fileObjects, fileObject)
+
+	object := k8sObject{
+		APIVersion: "v1",
+		Metadata:   c.metadata(name, nil),
+	}
+
+	switch fileObject.Kind {
+	case FileObjectKindSecret:
+		object.Kind = "Secret"
+		object.Type = "Opaque"
+		object.Data = map[string]string{
+			k8sFileObjectKey: base64.StdEncoding.EncodeToString(fileObject.Content),
+		}
+	case FileObjectKindConfig:
+		object.Kind = "ConfigMap"
+		if utf8.Valid(fileObject.Content) {
+			object.Data = map[string]string{
+				k8sFileObjectKey: string(fileObject.Content),
+			}
+		} else {
+			object.BinaryData = map[string]string{
+				k8sFileObjectKey: base64.StdEncoding.EncodeToString(fileObject.Content),
+			}
+		}
+	}
+
+	c.objects = append(c.objects, object)
+
+	return name
+}
+
+func (c *k8sConversion) ingress(
+	serviceName string,
+	route ingressRoute,
+	selector map[string]string,
+) (*k8sObject, error) {
+	matchers, err := parseTraefikV1Rule(route.settings.Rule)
+	if err != nil {
+		return nil, err
+	}
+
+	// auth modes are understood by Edgerouter. unprefixed by route name, as each route is its own object
+	annotations := map[string]string{}
+	for key, value := range route.auth {
+		annotations["edgerouter."+key] = value
+	}
+
+	hosts := []string{}
+	paths := []string{}
+	ruleType := ""
+
+	for _, matcher := range matchers {
+		switch matcher.kind {
+		case "Host":
+			hosts = append(hosts, matcher.values...)
+		case "Path", "PathPrefix", "PathStrip", "PathPrefixStrip":
+			paths = append(paths, matcher.values...)
+
+			// Traefik v1's Kubernetes provider supports these rule types as an annotation
+			if c.cluster.IngressDialect == IngressDialectTraefikV2 && matcher.kind != "PathPrefix" {
+				return nil, fmt.Errorf("ingress rule type %s not supported for Kubernetes with Traefik v2", matcher.kind)
+			}
+
+			// the annotation applies to all paths of the Ingress
+			if ruleType != "" && ruleType != matcher.kind {
+				return nil, fmt.Errorf("ingress rule types %s and %s cannot be mixed for Kubernetes", ruleType, matcher.kind)
+			}
+			ruleType = matcher.kind
+			annotations["traefik.ingress.kubernetes.io/rule-type"] = matcher.kind
+		default:
+			return nil, fmt.Errorf("ingress rule type %s not supported for Kubernetes", matcher.kind)
+		}
+	}
+
+	if len(hosts) == 0 {
+		hosts = []string{""} // matches all hosts
+	}
+
+	if len(paths) == 0 {
+		paths = []string{"/"} // matches all paths
+	}
+
+	rules := []k8sIngressRule{}
+	for _, host := range hosts {
+		rule := k8sIngressRule{Host: host}
+
+		for _, path := range paths {
+			rule.HTTP.Paths = append(rule.HTTP.Paths, k8sIngressPath{
+				Path:     path,
+				PathType: "Prefix", // exact matching etc. is up to rule-type annotation
+				Backend: k8sIngressBackend{
+					Service: k8sIngressServiceBackend{
+						Name: serviceName,
+						Port: k8sServiceBackendPort{Number: *route.settings.Port},
+					},
+				},
+			})
+		}
+
+		rules = append(rules, rule)
+	}
+
+	objectName := serviceName
+	if route.name != "" {
+		objectName = k8sName(serviceName + "-" + route.name)
+	}
+
+	metadata := c.metadata(objectName, selector)
+	for key, value := range annotations {
+		metadata.Annotations[key] = value
+	}
+
+	return &k8sObject{
+		APIVersion: "networking.k8s.io/v1",
+		Kind:       "Ingress",
+		Metadata:   metadata,
+		Spec:       k8sIngressSpec{Rules: rules},
+	}, nil
+}
+
+func (c *k8sConversion) metadata(name string, labels map[string]string) k8sMetadata {
+	allLabels := map[string]string{
+		"app.kubernetes.io/managed-by": "james",
+	}
+	for key, value := range labels {
+		allLabels[key] = value
+	}
+
+	return k8sMetadata{
+		Name:   name,
+		Labels: allLabels,
+		Annotations: map[string]string{
+			"james.ref": c.cluster.JamesRef,
+		},
+	}
+}
+
+var k8sNameInvalidChars = regexp.MustCompile("[^a-z0-9-]")
+
+// object and volume names must be DNS labels ("hellohttp_data" => "hellohttp-data")
+func k8sName(name string) string {
+	return strings.Trim(k8sNameInvalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// things Swarm has but Kubernetes (or our Kubernetes backend) doesn't
+func checkK8sUnsupported(service ServiceSpec) error {
+	unsupported := func(what string) error {
+		return fmt.Errorf("%s not supported for Kubernetes", what)
+	}
+
+	switch {
+	case len(service.Devices) > 0:
+		return unsupported("devices")
+	case service.StopSignal != "":
+		return unsupported("stop_signal")
+	case service.RestartPolicy != nil:
+		// pods of Deployments and DaemonSets are always restarted
+		return unsupported("restart_policy")
+	case len(service.Ulimits) > 0:
+		return unsupported("ulimit")
+	case len(service.Sysctls) > 0:
+		// would need to be allowed in each kubelet's config
+		return unsupported("sysctl")
+	default:
+		return nil
+	}
+}
+
+// ports published to outside of the cluster via the routing mesh (host mode ones are hostPorts)
+func k8sPorts(service ServiceSpec) []k8sServicePort {
+	ports := []k8sServicePort{}
+
+	add := func(specPorts []Port, protocol string) {
+		for _, port := range expandPortRanges(specPorts) {
+			if portMode(port) == portModeHost {
+				continue
+			}
+
+			ports = append(ports, k8sServicePort{
+				Name:       fmt.Sprintf("%s-%d", strings.ToLower(protocol), port.Public),
+				Protocol:   protocol,
+				Port:       port.Public,
+				TargetPort: port.Container,
+			})
+		}
+	}
+
+	add(service.TcpPorts, "TCP")
+	add(service.UdpPorts, "UDP")
+
+	return ports
+}
+
+func hasServicePort(ports []k8sServicePort, port uint32) bool {
+	for _, existing := range ports {
+		if existing.Protocol == "TCP" && existing.Port == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+func k8sEnvs(service ServiceSpec) []k8sEnvVar {
+	envs := map[string]string{
+		// see convertOneService() for explanation
+		"LOGGER_SUPPRESS_TIMESTAMPS": "1",
+	}
+
+	for _, env := range service.ENVs {
+		envs[env.Key] = env.Value
+	}
+
+	keys := []string{}
+	for key := range envs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	k8sEnvs := []k8sEnvVar{}
+	for _, key := range keys {
+		k8sEnvs = append(k8sEnvs, k8sEnvVar{Name: key, Value: envs[key]})
+	}
+
+	return k8sEnvs
+}
+
+// Kubernetes only accepts numeric user and group
+func k8sSecurityContextFor(service ServiceSpec) (*k8sSecurityContext, error) {
+	securityContext := &k8sSecurityContext{
+		Privileged: service.Privileged,
+	}
+
+	if service.User != "" {
+		userAndGroup := strings.SplitN(service.User, ":", 2)
+
+		uid, err := strconv.ParseInt(userAndGroup[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("user must be numeric for Kubernetes: %s", service.User)
+		}
+		securityContext.RunAsUser = &uid
+
+		if len(userAndGroup) == 2 {
+			gid, err := strconv.ParseInt(userAndGroup[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("group must be numeric for Kubernetes: %s", service.User)
+			}
+			securityContext.RunAsGroup = &gid
+		}
+	}
+
+	if len(service.Caps) > 0 {
+		securityContext.Capabilities = &k8sCapabilities{}
+		for _, capability := range service.Caps {
+			// Docker accepts both "CAP_NET_ADMIN" and "NET_ADMIN", Kubernetes only the latter
+			securityContext.Capabilities.Add = append(
+				securityContext.Capabilities.Add,
+				capabilityName(capability))
+		}
+	}
+
+	if !securityContext.Privileged && securityContext.RunAsUser == nil && securityContext.Capabilities == nil {
+		return nil, nil
+	}
+
+	return securityContext, nil
+}
+
+// readiness, not liveness: like Swarm, keeps traffic away from unhealthy tasks and makes
+// rolling updates wait for new ones to become healthy
+func k8sReadinessProbe(healthcheck Healthcheck) (*k8sProbe, error) {
+	// also validates
+	composeHealthcheck, err := convertHealthcheck(healthcheck)
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := func(duration *time.Duration) int64 {
+		if duration == nil {
+			return 0 // Kubernetes' default
+		}
+
+		return durationSeconds(*duration)
+	}
+
+	probe := &k8sProbe{
+		Exec:                k8sExecAction{Command: healthcheck.Command},
+		InitialDelaySeconds: seconds(composeHealthcheck.StartPeriod),
+		PeriodSeconds:       seconds(composeHealthcheck.Interval),
+		TimeoutSeconds:      seconds(composeHealthcheck.Timeout),
+	}
+
+	if healthcheck.Retries != nil {
+		probe.FailureThreshold = *healthcheck.Retries
+	}
+
+	return probe, nil
+}
+
+// Swarm's update_config maps only partially: delay, monitor and failure action have no
+// equivalent in Kubernetes' rolling updates (and rollback_config is rejected earlier).
+// stop-first keeps no extra pod around, so with unset parallelism (1) a Deployment of 0-1
+// replicas is down for the duration of the update - like on Swarm. use start-first to avoid that
+func k8sDeploymentStrategy(strategy updateStrategy) *k8sWorkloadStrategy {
+	rollingUpdate := &k8sRollingUpdate{}
+
+	parallelism := k8sParallelism(strategy)
+
+	if strategy.update.Order == "start-first" {
+		rollingUpdate.MaxSurge = parallelism
+		rollingUpdate.MaxUnavailable = 0
+	} else { // stop-first is Swarm's default
+		rollingUpdate.MaxSurge = 0
+		rollingUpdate.MaxUnavailable = parallelism
+	}
+
+	return &k8sWorkloadStrategy{
+		Type:          "RollingUpdate",
+		RollingUpdate: rollingUpdate,
+	}
+}
+
+// DaemonSets (as of Kubernetes 1.18) can only stop old pods first
+func k8sDaemonSetStrategy(strategy updateStrategy) *k8sWorkloadStrategy {
+	parallelism := k8sParallelism(strategy)
+
+	return &k8sWorkloadStrategy{
+		Type: "RollingUpdate",
+		RollingUpdate: &k8sRollingUpdate{
+			MaxUnavailable: parallelism,
+		},
+	}
+}
+
+// Swarm's default parallelism is 1, and 0 means all at once
+func k8sParallelism(strategy updateStrategy) interface{} {
+	if strategy.update.Parallelism == nil {
+		return 1
+	}
+
+	if *strategy.update.Parallelism == 0 {
+		return "100%"
+	}
+
+	return *strategy.update.Parallelism
+}
+
+// relative targets are relative to where Swarm mounts these
+func k8sFileObjectTarget(kind FileObjectKind, mount FileObjectMount) string {
+	target := fileObjectTarget(mount)
+	if path.IsAbs(target) {
+		return target
+	}
+
+	if kind == FileObjectKindSecret {
+		return "/run/secrets/" + target
+	}
+
+	return "/" + target
+}
+
+// rounds up, since Kubernetes would interpret 0 as "use default"
+func durationSeconds(duration time.Duration) int64 {
+	seconds := int64(duration / time.Second)
+	if duration%time.Second != 0 {
+		seconds++
+	}
+
+	return seconds
+}
+
+// "CAP_NET_ADMIN" | "cap_net_admin" | "NET_ADMIN" => "NET_ADMIN". Docker accepts all of these
+func capabilityName(capability string) string {
+	return strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+}
@@ -0,0 +1,41 @@
+package servicespec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestSpecToK8s(t *testing.T) {
+	tests := []testcase{
+		caseFromFile("k8sKitchenSink"),
+		caseFromFile("k8sGlobalService"),
+		caseFromFile("k8sDevicesUnsupported"),
+		caseFromFile("k8sRollbackConfigUnsupported"),
+		caseFromFile("k8sIngressWithoutPort"),
+		caseFromFile("k8sIngressNamed"),
+		caseFromFile("k8sIngressMixedRuleTypes"),
+		caseFromFile("k8sHostPorts"),
+	}
+
+	for _, test := range tests {
+		test := test // pin
+
+		t.Run(test.title, func(t *testing.T) {
+			actualOutput, err := specToK8s(
+				bytes.NewBufferString(test.input),
+				"testdata/"+test.title+".vars",
+				"testdata",
+				test.clusterCtx)
+
+			if test.expectedError == "" {
+				assert.Assert(t, err == nil)
+				assert.EqualString(t, actualOutput, test.expectedOutput)
+			} else {
+				assert.Assert(t, err != nil)
+				assert.EqualString(t, err.Error(), test.expectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package servicespec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// security rules that specs are linted against before deploying. a service can opt out of a
+// rule with a policy_exemption block, which forces the reason to be written down
+type Policy struct {
+	Rules []PolicyRule `json:"rule" hcl:"rule,block"`
+}
+
+type PolicyRule struct {
+	Name        string   `json:"name" hcl:"name,label"`
+	Check       string   `json:"check" hcl:"check"`            // see policyChecks
+	Values      []string `json:"values" hcl:"values,optional"` // for "caps" and "bind_mount"
+	Description string   `json:"description" hcl:"description,optional"`
+}
+
+// returns a message for each way service violates the rule
+type policyCheck func(service ServiceSpec, rule PolicyRule) []string
+
+var policyChecks = map[string]policyCheck{
+	"privileged": func(service ServiceSpec, _ PolicyRule) []string {
+		if service.Privileged {
+			return []string{"privileged mode"}
+		}
+
+		return nil
+	},
+	"caps": func(service ServiceSpec, rule PolicyRule) []string {
+		violations := []string{}
+		denied := []string{}
+		for _, value := range rule.Values {
+			denied = append(denied, capabilityName(value))
+		}
+
+		for _, capability := range service.Caps {
+			if stringSliceContains(denied, capabilityName(capability)) {
+				violations = append(violations, fmt.Sprintf("capability %s", capability))
+			}
+		}
+
+		return violations
+	},
+	"bind_mount": func(service ServiceSpec, rule PolicyRule) []string {
+		violations := []string{}
+		for _, bindMount := range service.BindMounts {
+			for _, denied := range rule.Values {
+				// mounting a parent directory exposes the denied path as well
+				if isSameOrParentPath(bindMount.Host, denied) {
+					violations = append(violations, fmt.Sprintf("bind mount %s", bindMount.Host))
+					break
+				}
+			}
+		}
+
+		return violations
+	},
+	"host_namespaces": func(service ServiceSpec, _ PolicyRule) []string {
+		if service.NetHost && service.PidHost {
+			return []string{"both net_host and pid_host"}
+		}
+
+		return nil
+	},
+	"public_ingress_with_persistent_volumes": func(service ServiceSpec, _ PolicyRule) []string {
+		if len(service.PersistentVolumes) == 0 {
+			return nil
+		}
+
+		for _, route := range serviceIngresses(service) {
+			if route.auth["auth"] == ingressAuthPublic {
+				return []string{"public ingress on a service with persistent volumes"}
+			}
+		}
+
+		return nil
+	},
+}
+
+type PolicyViolation struct {
+	Service string // "service foo" | "global_service foo"
+	Rule    string
+	Message string
+}
+
+func (p PolicyViolation) String() string {
+	return fmt.Sprintf("%s: policy rule %s: %s", p.Service, p.Rule, p.Message)
+}
+
+func ReadPolicyFile(policyPath string) (*Policy, error) {
+	content, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePolicy(content, policyPath)
+}
+
+// returns nil policy if the file does not exist, i.e. policy is opt-in
+func ReadPolicyFileIfExists(policyPath string) (*Policy, error) {
+	policy, err := ReadPolicyFile(policyPath)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return policy, err
+}
+
+func parsePolicy(content []byte, filename string) (*Policy, error) {
+	file, diags := hclsyntax.ParseConfig(content, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	policy := &Policy{}
+	if diags := gohcl.DecodeBody(file.Body, nil, policy); diags.HasErrors() {
+		return nil, diags
+	}
+
+	names := map[string]bool{}
+	for _, rule := range policy.Rules {
+		if names[rule.Name] {
+			return nil, fmt.Errorf("policy rule %s: already defined", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if _, found := policyChecks[rule.Check]; !found {
+			return nil, fmt.Errorf("policy rule %s: unknown check: %s", rule.Name, rule.Check)
+		}
+
+		if (rule.Check == "caps" || rule.Check == "bind_mount") && len(rule.Values) == 0 {
+			return nil, fmt.Errorf("policy rule %s: check %s requires values", rule.Name, rule.Check)
+		}
+	}
+
+	return policy, nil
+}
+
+// exemptions are matched by rule name, so one can't accidentally exempt more than intended.
+// exemption for a rule the policy doesn't have is an error, as it's likely a typo that would
+// leave the rule it meant to exempt in effect (or the rule was renamed and exemption is stale)
+func CheckPolicy(spec *SpecFile, policy Policy) ([]PolicyViolation, error) {
+	ruleNames := map[string]bool{}
+	for _, rule := range policy.Rules {
+		ruleNames[rule.Name] = true
+	}
+
+	violations := []PolicyViolation{}
+
+	checkServices := func(services []ServiceSpec, blockType string) error {
+		for _, service := range services {
+			exempted := map[string]bool{}
+			for _, exemption := range service.PolicyExemptions {
+				if !ruleNames[exemption.Rule] {
+					return fmt.Errorf("%s %s: policy_exemption %s: no such policy rule", blockType, service.Name, exemption.Rule)
+				}
+
+				exempted[exemption.Rule] = true
+			}
+
+			for _, rule := range policy.Rules {
+				if exempted[rule.Name] {
+					continue
+				}
+
+				for _, message := range policyChecks[rule.Check](service, rule) {
+					if rule.Description != "" {
+						message += " (" + rule.Description + ")"
+					}
+
+					violations = append(violations, PolicyViolation{
+						Service: blockType + " " + service.Name,
+						Rule:    rule.Name,
+						Message: message,
+					})
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := checkServices(spec.Services, "service"); err != nil {
+		return nil, err
+	}
+
+	if err := checkServices(spec.GlobalServices, "global_service"); err != nil {
+		return nil, err
+	}
+
+	for _, job := range spec.CronJobs {
+		service := job.ServiceSpec
+		service.Name = job.Name
+
+		if err := checkServices([]ServiceSpec{service}, "cron_job"); err != nil {
+			return nil, err
+		}
+	}
+
+	return violations, nil
+}
+
+func CheckPolicyByPath(specPath string, clusterCtx ClusterContext, policy Policy) ([]PolicyViolation, error) {
+	specFile, err := os.Open(specPath)
+	if err != nil {
+		return nil, err
+	}
+	defer specFile.Close()
+
+	spec, err := parseSpecFile(
+		specFile,
+		VarOverridesPath(specPath, clusterCtx.ID),
+		filepath.Dir(specPath),
+		clusterCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	return CheckPolicy(spec, policy)
+}
+
+// "/var/run/docker.sock" is exposed by mounting it, "/var/run", "/var" or "/"
+func isSameOrParentPath(mounted string, denied string) bool {
+	mounted = path.Clean(mounted)
+	denied = path.Clean(denied)
+
+	return mounted == denied || mounted == "/" || strings.HasPrefix(denied, mounted+"/")
+}
@@ -0,0 +1,80 @@
+package servicespec
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	policy, err := ReadPolicyFile("testdata/policy.hcl")
+	assert.Assert(t, err == nil)
+
+	specFile, err := os.Open("testdata/policyViolations.hcl")
+	assert.Assert(t, err == nil)
+	defer specFile.Close()
+
+	spec, err := parseSpecFile(specFile, "testdata/nonexistent.vars", "testdata", testClusterContext)
+	assert.Assert(t, err == nil)
+
+	policyViolations, err := CheckPolicy(spec, *policy)
+	assert.Assert(t, err == nil)
+
+	violations := []string{}
+	for _, violation := range policyViolations {
+		violations = append(violations, violation.String())
+	}
+
+	assert.EqualString(t, strings.Join(violations, "\n"), `service portainer: policy rule no-docker-socket: bind mount /var/run (gives root on the host)
+service vpn: policy rule no-dangerous-caps: capability CAP_NET_ADMIN
+service vpn: policy rule no-dangerous-caps: capability SYS_ADMIN
+service wiki: policy rule no-public-stateful: public ingress on a service with persistent volumes
+global_service agent: policy rule no-host-namespaces: both net_host and pid_host`)
+}
+
+func TestCheckPolicyUnknownExemption(t *testing.T) {
+	policy, err := ReadPolicyFile("testdata/policy.hcl")
+	assert.Assert(t, err == nil)
+
+	spec, err := parseSpecFile(strings.NewReader(`
+service "agent" {
+  image = "joonas/agent"
+  version = "v1"
+  how_to_update = "stop-old-first"
+  ram_mb = 64
+  privileged = true
+
+  policy_exemption "no-privilege" {
+    reason = "needs to manage host's devices"
+  }
+}
+`), "testdata/nonexistent.vars", "testdata", testClusterContext)
+	assert.Assert(t, err == nil)
+
+	_, err = CheckPolicy(spec, *policy)
+	assert.EqualString(t, err.Error(), "service agent: policy_exemption no-privilege: no such policy rule")
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	for _, tc := range []struct {
+		input         string
+		expectedError string
+	}{
+		{`rule "foo" { check = "yolo" }`, "policy rule foo: unknown check: yolo"},
+		{`rule "foo" { check = "caps" }`, "policy rule foo: check caps requires values"},
+		{`rule "foo" { check = "privileged" }
+rule "foo" { check = "privileged" }`, "policy rule foo: already defined"},
+	} {
+		tc := tc // pin
+
+		t.Run(tc.expectedError, func(t *testing.T) {
+			_, err := parsePolicy([]byte(tc.input), "policy.hcl")
+			assert.EqualString(t, err.Error(), tc.expectedError)
+		})
+	}
+
+	policy, err := ReadPolicyFileIfExists("testdata/nonexistent.hcl")
+	assert.Assert(t, err == nil && policy == nil)
+}
@@ -1,39 +1,67 @@
 package servicespec
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
+	"time"
 
 	composetypes "github.com/docker/cli/cli/compose/types"
 	"github.com/go-yaml/yaml"
-	"github.com/hashicorp/hcl/v2/hclsimple"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 )
 
-var one = uint64(1)
-var knownUpdateConfigs = map[string]*composetypes.UpdateConfig{
-	"parallel-one-at-a-time": {
-		Parallelism: &one,
-		Order:       "start-first",
-	},
-	"stop-old-first": {
-		Order: "stop-first",
-	},
+// Docker limits secret and config names to 64 characters
+const maxFileObjectNameLen = 64
+
+// publishing modes of tcp_port and udp_port
+const (
+	portModeIngress = "ingress" // Swarm's routing mesh, reachable from each node
+	portModeHost    = "host"    // only on the node the task runs on, but sees client IPs
+)
+
+// resolves image tag to its content digest ("sha256:...")
+type ImageDigestResolver func(image string, tag string) (string, error)
+
+// things shared by all services of a spec file
+type conversionContext struct {
+	specDir            string
+	updateStrategies   map[string]updateStrategy
+	cluster            ClusterContext
+	resolveImageDigest ImageDigestResolver // nil = don't pin
 }
 
-func convertOneService(service ServiceSpec, isGlobal bool, compose *composetypes.Config, defaults Defaults) error {
+func convertOneService(
+	service ServiceSpec,
+	isGlobal bool,
+	compose *composetypes.Config,
+	convCtx conversionContext,
+) ([]FileObject, error) {
+	service = withDefaults(service, convCtx.cluster.Defaults)
+
 	// most of the "not empty" checks carried out by HCL layer
+	if problems := checkService(service, isGlobal, convCtx.updateStrategies); len(problems) > 0 {
+		return nil, errors.New(problems[0].message)
+	}
 
 	envs, err := convertEnvs(service)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	labels := composetypes.Labels{}
+	for key, value := range convCtx.cluster.Defaults.Labels {
+		labels[key] = value
+	}
 
 	// try to tell the image's logger system to omit timestamps, since Docker adds those anyway
 	// https://github.com/function61/gokit/blob/7397b370de1295275a4670bce87cc8f5f64e33fa/logex/helpers.go#L27
@@ -41,9 +69,18 @@ func convertOneService(service ServiceSpec, isGlobal bool, compose *composetypes
 	envs["LOGGER_SUPPRESS_TIMESTAMPS"] = &one
 
 	if backup := service.Backup; backup != nil {
-		labels["ubackup.command"] = backup.Command
+		backupLabels, err := backupLabels(*backup)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range backupLabels {
+			labels[key] = value
+		}
 
-		envs["BACKUP_COMMAND"] = &backup.Command // deprecated, remove later
+		if convCtx.cluster.LegacyBackupCommandEnv { // for clusters running old ubackup
+			envs["BACKUP_COMMAND"] = &backup.Command
+		}
 	}
 
 	volumes := []composetypes.ServiceVolumeConfig{}
@@ -67,57 +104,57 @@ func convertOneService(service ServiceSpec, isGlobal bool, compose *composetypes
 		})
 	}
 
+	for _, tmpfs := range service.Tmpfs {
+		volume := composetypes.ServiceVolumeConfig{
+			Type:   "tmpfs",
+			Target: tmpfs.Target,
+		}
+
+		if tmpfs.SizeMb != nil {
+			volume.Tmpfs = &composetypes.ServiceVolumeTmpfs{
+				Size: int64(*tmpfs.SizeMb * 1024 * 1024),
+			}
+		}
+
+		volumes = append(volumes, volume)
+	}
+
 	deployMode := "" // will default to "replicated"
 	if isGlobal {
 		deployMode = "global"
 	}
 
-	// forcing user explicitly to tell this because incorrect config is dangerous
-	updateConfig := knownUpdateConfigs[service.HowToUpdate]
-	if updateConfig == nil {
-		return fmt.Errorf("unknown HowToUpdate: %s", service.HowToUpdate)
-	}
-
-	if countTrue(service.IngressPublic != nil, service.IngressBearer != nil, service.IngressSso != nil) > 1 {
-		return errors.New("maximum of one ingress per service exceeded")
-	}
+	updateStrategy := convCtx.updateStrategies[service.HowToUpdate] // existence validated by checkService()
 
-	if ingress := service.IngressPublic; ingress != nil {
-		labels["traefik.frontend.rule"] = ingress.Rule
-		if ingress.Port != nil {
-			labels["traefik.port"] = strconv.Itoa(*ingress.Port)
+	for _, route := range serviceIngresses(service) {
+		if err := addIngressRoutingLabels(labels, convCtx.cluster.stackName(), service.Name, route, convCtx.cluster.IngressDialect); err != nil {
+			return nil, err
 		}
 
-		// requires explicit opt-in, so a key missing does not accidentally expose endpoints to public
-		labels["edgerouter.auth"] = "public"
-	}
-
-	if ingress := service.IngressBearer; ingress != nil {
-		labels["traefik.frontend.rule"] = ingress.Rule
-		if ingress.Port != nil {
-			labels["traefik.port"] = strconv.Itoa(*ingress.Port)
+		for key, value := range route.authLabels() {
+			labels[key] = value
 		}
+	}
 
-		labels["edgerouter.auth"] = "bearer_token"
-		labels["edgerouter.auth_bearer_token"] = ingress.Token
+	resources, err := convertResources(service)
+	if err != nil {
+		return nil, err
 	}
 
-	if ingress := service.IngressSso; ingress != nil {
-		labels["traefik.frontend.rule"] = ingress.Rule
-		if ingress.Port != nil {
-			labels["traefik.port"] = strconv.Itoa(*ingress.Port)
+	image := service.Image + ":" + service.Version
+	if convCtx.resolveImageDigest != nil {
+		digest, err := convCtx.resolveImageDigest(service.Image, service.Version)
+		if err != nil {
+			return nil, err
 		}
 
-		labels["edgerouter.auth"] = "sso"
-		labels["edgerouter.auth_sso.tenant"] = ingress.Tenant
-		labels["edgerouter.auth_sso.users"] = strings.Join(ingress.Users, ",")
+		// a re-pushed tag now shows up in the diff. tag is kept for readability (Docker ignores it)
+		image += "@" + digest
 	}
 
-	ramBytes := composetypes.UnitBytes(service.RamMb) * 1024 * 1024
-
 	composeService := composetypes.ServiceConfig{
 		Name:        service.Name,
-		Image:       service.Image + ":" + service.Version,
+		Image:       image,
 		Command:     service.Command,
 		Environment: envs,
 		Volumes:     volumes,
@@ -128,21 +165,56 @@ func convertOneService(service ServiceSpec, isGlobal bool, compose *composetypes
 		Ports:       convertPorts(service),
 		Labels:      labels, // TODO: duplicated here. needed for Edgerouter when in host networking mode
 		Deploy: composetypes.DeployConfig{
-			Mode:         deployMode,
-			Labels:       labels,
-			Placement:    composetypes.Placement{},
-			UpdateConfig: updateConfig,
-			Resources: composetypes.Resources{
-				Limits: &composetypes.Resource{
-					MemoryBytes: ramBytes,
-				},
-			},
+			Mode:           deployMode,
+			Labels:         labels,
+			Placement:      composetypes.Placement{},
+			UpdateConfig:   updateStrategy.update,
+			RollbackConfig: updateStrategy.rollback,
+			Resources:      *resources,
 		},
 		Networks: map[string]*composetypes.ServiceNetworkConfig{},
 	}
 
-	if isGlobal && service.Replicas != nil {
-		return errors.New("global services cannot have 'replicas' defined")
+	if healthcheck := service.Healthcheck; healthcheck != nil {
+		composeService.HealthCheck, err = convertHealthcheck(*healthcheck)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if restartPolicy := service.RestartPolicy; restartPolicy != nil {
+		composeService.Deploy.RestartPolicy, err = convertRestartPolicy(*restartPolicy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	composeService.StopGracePeriod, err = parseOptionalDuration(service.StopGracePeriod)
+	if err != nil {
+		return nil, fmt.Errorf("stop_grace_period: %w", err)
+	}
+
+	composeService.StopSignal = service.StopSignal
+
+	composeService.Ulimits, err = convertUlimits(service.Ulimits)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sysctl := range service.Sysctls {
+		composeService.Sysctls = append(composeService.Sysctls, sysctl.Key+"="+sysctl.Value)
+	}
+
+	composeService.ExtraHosts, err = convertExtraHosts(service.ExtraHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	if driver := convCtx.cluster.Defaults.LoggingDriver; driver != "" {
+		composeService.Logging = &composetypes.LoggingConfig{
+			Driver:  driver,
+			Options: convCtx.cluster.Defaults.LoggingOptions,
+		}
 	}
 
 	composeService.Deploy.Replicas = service.Replicas
@@ -162,7 +234,7 @@ func convertOneService(service ServiceSpec, isGlobal bool, compose *composetypes
 	} else {
 		createNetworkConfigIfNotExists(compose, "default", composetypes.NetworkConfig{
 			External: composetypes.External{
-				Name: defaults.DockerNetworkName,
+				Name: convCtx.cluster.Defaults.DockerNetworkName,
 			},
 		})
 
@@ -174,92 +246,184 @@ func convertOneService(service ServiceSpec, isGlobal bool, compose *composetypes
 		composeService.Deploy.Placement.Constraints = []string{
 			"node.hostname == " + service.PlacementNodeHostname,
 		}
-	} else {
-		// prevent human errors
-		if len(service.PersistentVolumes) > 0 {
-			return errors.New("persistent volumes defined but no placement hostname defined")
-		}
 	}
 
-	/*
-		there are stateful volumes that can be shared between concurrent containers (file uploads @ erotuomari.com)
-		if len(service.PersistentVolumes) > 0 && service.HowToUpdate != "stop-old-first" {
-			return errors.New("expecting stop-old-first when have PersistentVolumes")
-		}
-	*/
-
-	if len(service.PersistentVolumes) > 0 && service.Backup == nil {
-		return errors.New(`stateful service - define at least empty backup section if you really don't want backups`)
+	fileObjects, err := convertFileObjects(service, &composeService, compose, convCtx.specDir)
+	if err != nil {
+		return nil, err
 	}
 
 	compose.Services = append(compose.Services, composeService)
 
-	return nil
+	return fileObjects, nil
 }
 
-func specToComposeConfig(spec *SpecFile, defaults Defaults) (*composetypes.Config, error) {
+func specToComposeConfig(
+	spec *SpecFile,
+	specDir string,
+	clusterCtx ClusterContext,
+	resolveImageDigest ImageDigestResolver,
+) (*composetypes.Config, []FileObject, error) {
+	if clusterCtx.Defaults.DockerNetworkName == "" {
+		return nil, nil, errors.New("cluster defaults: DockerNetworkName not set")
+	}
+
 	compose := &composetypes.Config{
 		Version:  "3.5",
 		Volumes:  map[string]composetypes.VolumeConfig{},
 		Networks: map[string]composetypes.NetworkConfig{},
+		Secrets:  map[string]composetypes.SecretConfig{},
+		Configs:  map[string]composetypes.ConfigObjConfig{},
 	}
 
-	for _, service := range spec.Services {
-		if err := convertOneService(service, false, compose, defaults); err != nil {
-			return nil, err
+	updateStrategies, err := resolveUpdateStrategies(spec.UpdateStrategies)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	convCtx := conversionContext{
+		specDir:            specDir,
+		updateStrategies:   updateStrategies,
+		cluster:            clusterCtx,
+		resolveImageDigest: resolveImageDigest,
+	}
+
+	fileObjects := []FileObject{}
+
+	convertServices := func(services []ServiceSpec, isGlobal bool) error {
+		for _, service := range services {
+			serviceFileObjects, err := convertOneService(service, isGlobal, compose, convCtx)
+			if err != nil {
+				return err
+			}
+
+			fileObjects = appendUniqueFileObjects(fileObjects, serviceFileObjects...)
 		}
+
+		return nil
 	}
 
-	for _, service := range spec.GlobalServices {
-		if err := convertOneService(service, true, compose, defaults); err != nil {
-			return nil, err
+	if err := convertServices(spec.Services, false); err != nil {
+		return nil, nil, err
+	}
+
+	if err := convertServices(spec.GlobalServices, true); err != nil {
+		return nil, nil, err
+	}
+
+	for _, job := range spec.CronJobs {
+		jobFileObjects, err := convertCronJob(job, compose, convCtx)
+		if err != nil {
+			return nil, nil, err
 		}
+
+		fileObjects = appendUniqueFileObjects(fileObjects, jobFileObjects...)
 	}
 
-	return compose, nil
+	// tmpfs size needs 3.6. not bumping for all, so already deployed stacks don't show up in diffs
+	if usesTmpfsSize(compose) {
+		compose.Version = "3.6"
+	}
+
+	return compose, fileObjects, nil
 }
 
-func parseSpecFile(content io.Reader) (*SpecFile, error) {
+func parseSpecFile(
+	content io.Reader,
+	varOverridesPath string,
+	specDir string,
+	clusterCtx ClusterContext,
+) (*SpecFile, error) {
 	buf, err := ioutil.ReadAll(content)
 	if err != nil {
 		return nil, err
 	}
 
+	spec, _, diags := decodeSpecFile(buf, "dummy.hcl", varOverridesPath, specDir, clusterCtx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return spec, nil
+}
+
+// also returns the parsed file (if syntax was valid) for mapping problems to source locations
+func decodeSpecFile(
+	content []byte,
+	filename string,
+	varOverridesPath string,
+	specDir string,
+	clusterCtx ClusterContext,
+) (*SpecFile, *hcl.File, hcl.Diagnostics) {
+	file, diags := hclsyntax.ParseConfig(content, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+
+	evalCtx, diags := evalContextWithVariables(file.Body, varOverridesPath, specDir, clusterCtx)
+	if diags.HasErrors() {
+		return nil, file, diags
+	}
+
+	templates, diags := readTemplates(file.Body, clusterCtx.TemplatesDir)
+	if diags.HasErrors() {
+		return nil, file, diags
+	}
+
+	body := &templatedSpecBody{Body: file.Body, templates: templates}
+
 	spec := &SpecFile{}
-	return spec, hclsimple.Decode("dummy.hcl", buf, nil, spec)
+	if diags := gohcl.DecodeBody(body, evalCtx, spec); diags.HasErrors() {
+		return nil, file, diags
+	}
+
+	return spec, file, nil
 }
 
-func SpecToComposeByPath(path string) (string, error) {
+// returns compose YAML and the secrets/configs it references (these must exist before deploying).
+// resolveImageDigest is optional.
+func SpecToComposeByPath(
+	path string,
+	clusterCtx ClusterContext,
+	resolveImageDigest ImageDigestResolver,
+) (string, []FileObject, error) {
 	specFile, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	defer specFile.Close()
 
-	return specToCompose(specFile)
+	return specToCompose(
+		specFile,
+		VarOverridesPath(path, clusterCtx.ID),
+		filepath.Dir(path),
+		clusterCtx,
+		resolveImageDigest)
 }
 
-func specToCompose(content io.Reader) (string, error) {
-	defaults := Defaults{
-		DockerNetworkName: "fn61",
-	}
-
-	spec, err := parseSpecFile(content)
+func specToCompose(
+	content io.Reader,
+	varOverridesPath string,
+	specDir string,
+	clusterCtx ClusterContext,
+	resolveImageDigest ImageDigestResolver,
+) (string, []FileObject, error) {
+	spec, err := parseSpecFile(content, varOverridesPath, specDir, clusterCtx)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	composeConfig, err := specToComposeConfig(spec, defaults)
+	composeConfig, fileObjects, err := specToComposeConfig(spec, specDir, clusterCtx, resolveImageDigest)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	yamlBytes, err := yaml.Marshal(&composeConfig)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return string(yamlBytes), nil
+	return string(yamlBytes), fileObjects, nil
 }
 
 func convertEnvs(service ServiceSpec) (composetypes.MappingWithEquals, error) {
@@ -272,13 +436,196 @@ func convertEnvs(service ServiceSpec) (composetypes.MappingWithEquals, error) {
 	return envs, nil
 }
 
+func convertResources(service ServiceSpec) (*composetypes.Resources, error) {
+	ramMb := *service.RamMb // existence validated by checkService()
+
+	megabytes := func(mb uint64) composetypes.UnitBytes {
+		return composetypes.UnitBytes(mb) * 1024 * 1024
+	}
+
+	resources := &composetypes.Resources{
+		Limits: &composetypes.Resource{
+			MemoryBytes: megabytes(ramMb),
+		},
+	}
+
+	if service.Cpus != nil {
+		if *service.Cpus <= 0 {
+			return nil, errors.New("cpus must be > 0")
+		}
+
+		resources.Limits.NanoCPUs = formatCpus(*service.Cpus)
+	}
+
+	if service.RamReservationMb == nil && service.CpusReservation == nil {
+		return resources, nil
+	}
+
+	resources.Reservations = &composetypes.Resource{}
+
+	if service.RamReservationMb != nil {
+		// a reservation the limit doesn't allow to use would only make the service unschedulable.
+		// 0 = no limit
+		if ramMb != 0 && *service.RamReservationMb > ramMb {
+			return nil, fmt.Errorf(
+				"ram_reservation_mb (%d) larger than ram_mb (%d)",
+				*service.RamReservationMb,
+				ramMb)
+		}
+
+		resources.Reservations.MemoryBytes = megabytes(*service.RamReservationMb)
+	}
+
+	if service.CpusReservation != nil {
+		if service.Cpus != nil && *service.CpusReservation > *service.Cpus {
+			return nil, fmt.Errorf(
+				"cpus_reservation (%s) larger than cpus (%s)",
+				formatCpus(*service.CpusReservation),
+				formatCpus(*service.Cpus))
+		}
+
+		resources.Reservations.NanoCPUs = formatCpus(*service.CpusReservation)
+	}
+
+	return resources, nil
+}
+
+// 0.5 => "0.5"
+func formatCpus(cpus float64) string {
+	return strconv.FormatFloat(cpus, 'f', -1, 64)
+}
+
+func convertHealthcheck(healthcheck Healthcheck) (*composetypes.HealthCheckConfig, error) {
+	if len(healthcheck.Command) == 0 {
+		return nil, errors.New("healthcheck: empty command")
+	}
+
+	interval, err := parseOptionalDuration(healthcheck.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("healthcheck: interval: %w", err)
+	}
+
+	timeout, err := parseOptionalDuration(healthcheck.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("healthcheck: timeout: %w", err)
+	}
+
+	startPeriod, err := parseOptionalDuration(healthcheck.StartPeriod)
+	if err != nil {
+		return nil, fmt.Errorf("healthcheck: start_period: %w", err)
+	}
+
+	return &composetypes.HealthCheckConfig{
+		Test:        append(composetypes.HealthCheckTest{"CMD"}, healthcheck.Command...),
+		Interval:    interval,
+		Timeout:     timeout,
+		Retries:     healthcheck.Retries,
+		StartPeriod: startPeriod,
+	}, nil
+}
+
+func convertRestartPolicy(restartPolicy RestartPolicy) (*composetypes.RestartPolicy, error) {
+	switch restartPolicy.Condition {
+	case "", "none", "on-failure", "any":
+	default:
+		return nil, fmt.Errorf("restart_policy: unsupported condition: %s", restartPolicy.Condition)
+	}
+
+	delay, err := parseOptionalDuration(restartPolicy.Delay)
+	if err != nil {
+		return nil, fmt.Errorf("restart_policy: delay: %w", err)
+	}
+
+	window, err := parseOptionalDuration(restartPolicy.Window)
+	if err != nil {
+		return nil, fmt.Errorf("restart_policy: window: %w", err)
+	}
+
+	return &composetypes.RestartPolicy{
+		Condition:   restartPolicy.Condition,
+		Delay:       delay,
+		MaxAttempts: restartPolicy.MaxAttempts,
+		Window:      window,
+	}, nil
+}
+
+func convertUlimits(ulimits []Ulimit) (map[string]*composetypes.UlimitsConfig, error) {
+	if len(ulimits) == 0 {
+		return nil, nil
+	}
+
+	converted := map[string]*composetypes.UlimitsConfig{}
+
+	for _, ulimit := range ulimits {
+		if _, duplicate := converted[ulimit.Name]; duplicate {
+			return nil, fmt.Errorf("ulimit %s: already defined", ulimit.Name)
+		}
+
+		if ulimit.Soft > ulimit.Hard {
+			return nil, fmt.Errorf("ulimit %s: soft (%d) larger than hard (%d)", ulimit.Name, ulimit.Soft, ulimit.Hard)
+		}
+
+		converted[ulimit.Name] = &composetypes.UlimitsConfig{
+			Soft: ulimit.Soft,
+			Hard: ulimit.Hard,
+		}
+	}
+
+	return converted, nil
+}
+
+// "hostname:ip" (Swarm converts to /etc/hosts format)
+func convertExtraHosts(extraHosts []ExtraHost) (composetypes.HostsList, error) {
+	converted := composetypes.HostsList{}
+
+	for _, extraHost := range extraHosts {
+		if net.ParseIP(extraHost.IP) == nil {
+			return nil, fmt.Errorf("extra_host %s: invalid IP: %s", extraHost.Hostname, extraHost.IP)
+		}
+
+		converted = append(converted, extraHost.Hostname+":"+extraHost.IP)
+	}
+
+	if len(converted) == 0 {
+		return nil, nil
+	}
+
+	return converted, nil
+}
+
+func usesTmpfsSize(compose *composetypes.Config) bool {
+	for _, service := range compose.Services {
+		for _, volume := range service.Volumes {
+			if volume.Tmpfs != nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// "" => nil
+func parseOptionalDuration(input string) (*time.Duration, error) {
+	if input == "" {
+		return nil, nil
+	}
+
+	duration, err := time.ParseDuration(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &duration, nil
+}
+
 func convertPorts(service ServiceSpec) []composetypes.ServicePortConfig {
 	composePorts := []composetypes.ServicePortConfig{}
 
 	convertOneType := func(ports []Port, tcpOrUdp string) {
-		for _, port := range ports {
+		for _, port := range expandPortRanges(ports) {
 			composePorts = append(composePorts, composetypes.ServicePortConfig{
-				Mode:      "ingress",
+				Mode:      portMode(port),
 				Target:    port.Container,
 				Published: port.Public,
 				Protocol:  tcpOrUdp,
@@ -292,6 +639,138 @@ func convertPorts(service ServiceSpec) []composetypes.ServicePortConfig {
 	return composePorts
 }
 
+// compose's long syntax has no ranges (short syntax ranges are expanded as well)
+func expandPortRanges(ports []Port) []Port {
+	expanded := []Port{}
+
+	for _, port := range ports {
+		count := uint32(1)
+		if port.Count != nil {
+			count = *port.Count
+		}
+
+		for i := uint32(0); i < count; i++ {
+			expanded = append(expanded, Port{
+				Public:    port.Public + i,
+				Container: port.Container + i,
+				Mode:      port.Mode,
+			})
+		}
+	}
+
+	return expanded
+}
+
+func portMode(port Port) string {
+	if port.Mode == "" {
+		return portModeIngress
+	}
+
+	return port.Mode
+}
+
+func convertFileObjects(
+	service ServiceSpec,
+	composeService *composetypes.ServiceConfig,
+	compose *composetypes.Config,
+	specDir string,
+) ([]FileObject, error) {
+	fileObjects := []FileObject{}
+
+	for _, secret := range service.Secrets {
+		fileObject, err := readFileObject(FileObjectKindSecret, secret, specDir)
+		if err != nil {
+			return nil, err
+		}
+
+		// external because we create these ourselves (Portainer can't read our local files)
+		compose.Secrets[fileObject.Name] = composetypes.SecretConfig{
+			External: composetypes.External{External: true},
+		}
+
+		composeService.Secrets = append(composeService.Secrets, composetypes.ServiceSecretConfig{
+			Source: fileObject.Name,
+			Target: fileObjectTarget(secret),
+		})
+
+		fileObjects = append(fileObjects, *fileObject)
+	}
+
+	for _, config := range service.Configs {
+		fileObject, err := readFileObject(FileObjectKindConfig, config, specDir)
+		if err != nil {
+			return nil, err
+		}
+
+		compose.Configs[fileObject.Name] = composetypes.ConfigObjConfig{
+			External: composetypes.External{External: true},
+		}
+
+		composeService.Configs = append(composeService.Configs, composetypes.ServiceConfigObjConfig{
+			Source: fileObject.Name,
+			Target: fileObjectTarget(config),
+		})
+
+		fileObjects = append(fileObjects, *fileObject)
+	}
+
+	return fileObjects, nil
+}
+
+func readFileObject(kind FileObjectKind, mount FileObjectMount, specDir string) (*FileObject, error) {
+	path := mount.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(specDir, path)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", kind, mount.Name, err)
+	}
+
+	// secrets and configs are immutable in Swarm, so rotating content must produce a new name
+	contentHash := sha256.Sum256(content)
+	name := mount.Name + "-" + hex.EncodeToString(contentHash[:])[0:10]
+
+	if len(name) > maxFileObjectNameLen {
+		return nil, fmt.Errorf("%s %s: name too long", kind, mount.Name)
+	}
+
+	return &FileObject{
+		Kind:    kind,
+		Name:    name,
+		Content: content,
+	}, nil
+}
+
+// without explicit target the mount would get the hashed name, which would change on each rotation
+func fileObjectTarget(mount FileObjectMount) string {
+	if mount.Target != "" {
+		return mount.Target
+	}
+
+	return mount.Name
+}
+
+// different services can reference same content
+func appendUniqueFileObjects(fileObjects []FileObject, toAdd ...FileObject) []FileObject {
+	for _, item := range toAdd {
+		alreadyAdded := false
+		for _, existing := range fileObjects {
+			if existing.Kind == item.Kind && existing.Name == item.Name {
+				alreadyAdded = true
+				break
+			}
+		}
+
+		if !alreadyAdded {
+			fileObjects = append(fileObjects, item)
+		}
+	}
+
+	return fileObjects
+}
+
 func createNetworkConfigIfNotExists(compose *composetypes.Config, networkName string, config composetypes.NetworkConfig) {
 	if _, alreadyExists := compose.Networks[networkName]; alreadyExists {
 		return
@@ -9,11 +9,21 @@ import (
 	"github.com/function61/gokit/assert"
 )
 
+var testClusterContext = ClusterContext{
+	ID:       "prod5",
+	Domain:   "fn61.net",
+	JamesRef: "prod5:stacks/test.hcl",
+	Defaults: Defaults{
+		DockerNetworkName: "fn61",
+	},
+}
+
 type testcase struct {
 	title          string
 	input          string
 	expectedOutput string
 	expectedError  string
+	clusterCtx     ClusterContext
 }
 
 func TestSpecToCompose(t *testing.T) {
@@ -22,13 +32,64 @@ func TestSpecToCompose(t *testing.T) {
 		caseFromFile("kitchenSink"),
 		caseFromFile("howToUpdateMissing"),
 		caseFromFile("persistentVolumeWithoutPlacementNode"),
+		caseFromFile("secretsAndConfigs"),
+		caseFromFile("secretFileMissing"),
+		caseFromFile("healthcheck"),
+		caseFromFile("healthcheckInvalidDuration"),
+		caseFromFile("resources"),
+		caseFromFile("ramReservationOverLimit"),
+		caseFromFile("ramReservationWithoutLimit"),
+		caseFromFile("cpusReservationOverLimit"),
+		caseFromFile("updateStrategy"),
+		caseFromFile("updateStrategyFailureRollback"),
+		caseFromFile("updateStrategyUnknown"),
+		caseFromFile("updateStrategyShadowsBuiltin"),
+		caseFromFile("variables"),
+		caseFromFile("variableWithoutValue"),
+		caseFromFile("variableOverrideUndeclared"),
+		caseFromFile("restartAndStop"),
+		caseFromFile("restartPolicyInvalidCondition"),
+		withIngressDialect(caseFromFile("ingressTraefikV2"), IngressDialectTraefikV2),
+		withIngressDialect(caseFromFile("ingressTraefikV2UnsupportedRule"), IngressDialectTraefikV2),
+		withClusterDefaults(caseFromFile("clusterDefaults"), testClusterDefaults),
+		caseFromFile("ramMbMissing"),
+		caseFromFile("backup"),
+		caseFromFile("backupCompressionConflict"),
+		caseFromFile("backupDisabledWithSettings"),
+		withLegacyBackupCommandEnv(caseFromFile("backupLegacyCommandEnv")),
+		caseFromFile("ingressNamed"),
+		withIngressDialect(caseFromFile("ingressNamedTraefikV2"), IngressDialectTraefikV2),
+		caseFromFile("ingressRuleCollision"),
+		caseFromFile("tmpfs"),
+		caseFromFile("ulimits"),
+		caseFromFile("ulimitSoftOverHard"),
+		caseFromFile("sysctls"),
+		caseFromFile("extraHosts"),
+		caseFromFile("extraHostInvalidIp"),
+		withTemplatesDir(caseFromFile("templates"), "testdata/templates"),
+		caseFromFile("templateUnknown"),
+		caseFromFile("templateCycle"),
+		caseFromFile("templateRequiredMissing"),
+		caseFromFile("cronJob"),
+		caseFromFile("cronJobReplicas"),
+		caseFromFile("cronJobTimeoutTooShort"),
+		caseFromFile("cronJobPersistentVolumeWithoutPlacement"),
+		caseFromFile("portsHostModeAndRanges"),
+		caseFromFile("portHostModeWithoutPlacement"),
+		caseFromFile("portHostModeStartFirst"),
+		caseFromFile("portHostModeReplicas"),
 	}
 
 	for _, test := range tests {
 		test := test // pin
 
 		t.Run(test.title, func(t *testing.T) {
-			actualOutput, err := specToCompose(bytes.NewBufferString(test.input))
+			actualOutput, _, err := specToCompose(
+				bytes.NewBufferString(test.input),
+				"testdata/"+test.title+".vars",
+				"testdata",
+				test.clusterCtx,
+				nil)
 
 			if test.expectedError == "" {
 				assert.Assert(t, err == nil)
@@ -41,6 +102,25 @@ func TestSpecToCompose(t *testing.T) {
 	}
 }
 
+func TestSpecToComposePinsImageDigests(t *testing.T) {
+	test := caseFromFile("simple")
+
+	resolveImageDigest := func(image string, tag string) (string, error) {
+		assert.EqualString(t, image+":"+tag, "joonas/hellohttp:v2")
+
+		return "sha256:0123456789abcdef", nil
+	}
+
+	actualOutput, _, err := specToCompose(
+		bytes.NewBufferString(test.input),
+		"",
+		"testdata",
+		testClusterContext,
+		resolveImageDigest)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, strings.Contains(actualOutput, "image: joonas/hellohttp:v2@sha256:0123456789abcdef\n"))
+}
+
 func caseFromFile(title string) testcase {
 	buf, err := ioutil.ReadFile("testdata/" + title + ".txt")
 	if err != nil {
@@ -62,6 +142,7 @@ func caseFromFile(title string) testcase {
 			input:          parts[0],
 			expectedOutput: "",
 			expectedError:  expectedError,
+			clusterCtx:     testClusterContext,
 		}
 	} else {
 		return testcase{
@@ -69,6 +150,44 @@ func caseFromFile(title string) testcase {
 			input:          parts[0],
 			expectedOutput: parts[1],
 			expectedError:  "",
+			clusterCtx:     testClusterContext,
 		}
 	}
 }
+
+func withIngressDialect(test testcase, dialect IngressDialect) testcase {
+	test.clusterCtx.IngressDialect = dialect
+	return test
+}
+
+func withClusterDefaults(test testcase, defaults Defaults) testcase {
+	test.clusterCtx.Defaults = defaults
+	return test
+}
+
+func withLegacyBackupCommandEnv(test testcase) testcase {
+	test.clusterCtx.LegacyBackupCommandEnv = true
+	return test
+}
+
+func withTemplatesDir(test testcase, templatesDir string) testcase {
+	test.clusterCtx.TemplatesDir = templatesDir
+	return test
+}
+
+var testClusterDefaults = func() Defaults {
+	ramMb := uint64(128)
+
+	return Defaults{
+		DockerNetworkName: "prodnet",
+		HowToUpdate:       "stop-old-first",
+		RamMb:             &ramMb,
+		Labels: map[string]string{
+			"team": "platform",
+		},
+		LoggingDriver: "gelf",
+		LoggingOptions: map[string]string{
+			"gelf-address": "udp://logs.fn61.net:12201",
+		},
+	}
+}()
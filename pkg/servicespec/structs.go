@@ -1,50 +1,115 @@
 package servicespec
 
+import (
+	"github.com/hashicorp/hcl/v2"
+)
+
 type SpecFile struct {
 	// Stack          string        `json:"stack" hcl:"stack"`
-	Services       []ServiceSpec `json:"service" hcl:"service,block"`
-	GlobalServices []ServiceSpec `json:"global_service" hcl:"global_service,block"`
+	Variables        []Variable       `json:"variable" hcl:"variable,block"`
+	UpdateStrategies []UpdateStrategy `json:"update_strategy" hcl:"update_strategy,block"`
+	Templates        []Template       `json:"template" hcl:"template,block"`
+	Services         []ServiceSpec    `json:"service" hcl:"service,block"`
+	GlobalServices   []ServiceSpec    `json:"global_service" hcl:"global_service,block"`
+	CronJobs         []CronJob        `json:"cron_job" hcl:"cron_job,block"`
 }
 
 type ServiceSpec struct {
-	Name        string  `json:"name" hcl:"name,label"`
-	Image       string  `json:"image" hcl:"image"`
-	Replicas    *uint64 `json:"replicas" hcl:"replicas"`
-	HowToUpdate string  `json:"how_to_update" hcl:"how_to_update"`
-	Version     string  `json:"version" hcl:"version"`
-	ENVs        []struct {
-		Key   string `json:"key" hcl:"key,label"`
-		Value string `json:"value" hcl:"value"`
-	} `json:"env" hcl:"env,block"`
-	Command               []string `json:"command" hcl:"command,optional"`
-	Privileged            bool     `json:"privileged" hcl:"privileged,optional"`
-	Devices               []string `json:"devices" hcl:"devices,optional"`
-	User                  string   `json:"user" hcl:"user,optional"`
-	Caps                  []string `json:"caps" hcl:"caps,optional"`
-	PlacementNodeHostname string   `json:"placement_node_hostname" hcl:"placement_node_hostname,optional"`
-	IngressPublic         *struct {
-		SharedIngressSettings `hcl:",remain"`
-	} `json:"ingress_public" hcl:"ingress_public,block"`
-	IngressBearer *struct {
-		SharedIngressSettings `hcl:",remain"`
-		Token                 string `json:"token" hcl:"token"`
-	} `json:"ingress_bearer" hcl:"ingress_bearer,block"`
-	IngressSso *struct {
-		SharedIngressSettings `hcl:",remain"`
-		Users                 []string `json:"users" hcl:"users"`
-		Tenant                string   `json:"tenant" hcl:"tenant"`
-	} `json:"ingress_sso" hcl:"ingress_sso,block"`
-	Backup *struct {
-		Command string `json:"command" hcl:"command"`
-		// TODO: file extension
-	} `json:"backup" hcl:"backup,block"`
-	RamMb             uint64             `json:"ram_mb" hcl:"ram_mb"`
-	PidHost           bool               `json:"pid_host" hcl:"pid_host,optional"`
-	NetHost           bool               `json:"net_host" hcl:"net_host,optional"`
-	TcpPorts          []Port             `json:"tcp_port" hcl:"tcp_port,block"`
-	UdpPorts          []Port             `json:"udp_port" hcl:"udp_port,block"`
-	PersistentVolumes []PersistentVolume `json:"persistentvolume" hcl:"persistentvolume,block"`
-	BindMounts        []BindMount        `json:"bindmount" hcl:"bindmount,block"`
+	Name                  string             `json:"name" hcl:"name,label"`
+	Extends               string             `json:"extends" hcl:"extends,optional"` // name of template
+	Image                 string             `json:"image" hcl:"image"`
+	Replicas              *uint64            `json:"replicas" hcl:"replicas"`
+	HowToUpdate           string             `json:"how_to_update" hcl:"how_to_update,optional"` // defaults to cluster's
+	Version               string             `json:"version" hcl:"version"`
+	ENVs                  []EnvVar           `json:"env" hcl:"env,block"`
+	Command               []string           `json:"command" hcl:"command,optional"`
+	Privileged            bool               `json:"privileged" hcl:"privileged,optional"`
+	Devices               []string           `json:"devices" hcl:"devices,optional"`
+	User                  string             `json:"user" hcl:"user,optional"`
+	Caps                  []string           `json:"caps" hcl:"caps,optional"`
+	PlacementNodeHostname string             `json:"placement_node_hostname" hcl:"placement_node_hostname,optional"`
+	IngressPublic         *IngressPublic     `json:"ingress_public" hcl:"ingress_public,block"`
+	IngressBearer         *IngressBearer     `json:"ingress_bearer" hcl:"ingress_bearer,block"`
+	IngressSso            *IngressSso        `json:"ingress_sso" hcl:"ingress_sso,block"`
+	Ingresses             []Ingress          `json:"ingress" hcl:"ingress,block"`
+	Backup                *Backup            `json:"backup" hcl:"backup,block"`
+	Healthcheck           *Healthcheck       `json:"healthcheck" hcl:"healthcheck,block"`
+	RestartPolicy         *RestartPolicy     `json:"restart_policy" hcl:"restart_policy,block"`
+	StopGracePeriod       string             `json:"stop_grace_period" hcl:"stop_grace_period,optional"` // Go's duration format
+	StopSignal            string             `json:"stop_signal" hcl:"stop_signal,optional"`             // "SIGINT"
+	RamMb                 *uint64            `json:"ram_mb" hcl:"ram_mb,optional"`                       // defaults to cluster's. 0 = no limit
+	RamReservationMb      *uint64            `json:"ram_reservation_mb" hcl:"ram_reservation_mb,optional"`
+	Cpus                  *float64           `json:"cpus" hcl:"cpus,optional"`
+	CpusReservation       *float64           `json:"cpus_reservation" hcl:"cpus_reservation,optional"`
+	PidHost               bool               `json:"pid_host" hcl:"pid_host,optional"`
+	NetHost               bool               `json:"net_host" hcl:"net_host,optional"`
+	TcpPorts              []Port             `json:"tcp_port" hcl:"tcp_port,block"`
+	UdpPorts              []Port             `json:"udp_port" hcl:"udp_port,block"`
+	PersistentVolumes     []PersistentVolume `json:"persistentvolume" hcl:"persistentvolume,block"`
+	BindMounts            []BindMount        `json:"bindmount" hcl:"bindmount,block"`
+	Secrets               []FileObjectMount  `json:"secret" hcl:"secret,block"`
+	Configs               []FileObjectMount  `json:"config" hcl:"config,block"`
+	Tmpfs                 []Tmpfs            `json:"tmpfs" hcl:"tmpfs,block"`
+	Ulimits               []Ulimit           `json:"ulimit" hcl:"ulimit,block"`
+	Sysctls               []Sysctl           `json:"sysctl" hcl:"sysctl,block"`
+	ExtraHosts            []ExtraHost        `json:"extra_host" hcl:"extra_host,block"`
+	PolicyExemptions      []PolicyExemption  `json:"policy_exemption" hcl:"policy_exemption,block"`
+}
+
+// service that is run on schedule (not continuously). see convertCronJob()
+type CronJob struct {
+	Name        string `json:"name" hcl:"name,label"`
+	Schedule    string `json:"schedule" hcl:"schedule"`                  // cron format: "0 3 * * *"
+	SkipRunning bool   `json:"skip_running" hcl:"skip_running,optional"` // don't start if previous still running
+	Timeout     string `json:"timeout" hcl:"timeout,optional"`           // Go's duration format
+	ServiceSpec `hcl:",remain"`
+}
+
+type EnvVar struct {
+	Key   string `json:"key" hcl:"key,label"`
+	Value string `json:"value" hcl:"value"`
+}
+
+type IngressPublic struct {
+	SharedIngressSettings `hcl:",remain"`
+}
+
+type IngressBearer struct {
+	SharedIngressSettings `hcl:",remain"`
+	Token                 string `json:"token" hcl:"token"`
+}
+
+type IngressSso struct {
+	SharedIngressSettings `hcl:",remain"`
+	Users                 []string `json:"users" hcl:"users"`
+	Tenant                string   `json:"tenant" hcl:"tenant"`
+}
+
+// named ingress, for when a service has more than one route (e.g. public + SSO-protected admin)
+type Ingress struct {
+	Name                  string `json:"name" hcl:"name,label"`
+	Auth                  string `json:"auth" hcl:"auth"` // "public" | "bearer_token" | "sso"
+	SharedIngressSettings `hcl:",remain"`
+	Token                 string   `json:"token" hcl:"token,optional"`   // bearer_token
+	Users                 []string `json:"users" hcl:"users,optional"`   // sso
+	Tenant                string   `json:"tenant" hcl:"tenant,optional"` // sso
+}
+
+// empty command means "no backups" (stateful services must define backup section explicitly)
+type Backup struct {
+	Command       string           `json:"command" hcl:"command"`                        // writes backup to stdout
+	FileExtension string           `json:"file_extension" hcl:"file_extension,optional"` // ".sql"
+	Schedule      string           `json:"schedule" hcl:"schedule,optional"`             // cron format: "0 3 * * *"
+	Compression   string           `json:"compression" hcl:"compression,optional"`       // "none" | "gzip" | "zstd"
+	Retention     *BackupRetention `json:"retention" hcl:"retention,block"`
+	PreHook       string           `json:"pre_hook" hcl:"pre_hook,optional"`   // run before command, e.g. to flush writes
+	PostHook      string           `json:"post_hook" hcl:"post_hook,optional"` // run after command, even if it failed
+}
+
+// backups are pruned when either limit is exceeded
+type BackupRetention struct {
+	Count  *uint64 `json:"count" hcl:"count,optional"`
+	MaxAge string  `json:"max_age" hcl:"max_age,optional"` // Go's duration format ("720h")
 }
 
 // common to all ingresses (public/password/SSO)
@@ -53,9 +118,65 @@ type SharedIngressSettings struct {
 	Port *int   `json:"port" hcl:"port"`
 }
 
+// opts a service out of a policy rule (see Policy)
+type PolicyExemption struct {
+	Rule   string `json:"rule" hcl:"rule,label"`
+	Reason string `json:"reason" hcl:"reason"` // required, so the exemption gets reviewed along with the spec
+}
+
+// referenced as var.<name>. value can be overridden per cluster, see VarOverridesPath()
+type Variable struct {
+	Name        string         `json:"name" hcl:"name,label"`
+	Default     hcl.Expression `json:"default" hcl:"default,optional"`
+	Description string         `json:"description" hcl:"description,optional"`
+}
+
+// partial service that services can extend. see templates.go for how the bodies are merged
+type Template struct {
+	Name    string   `json:"name" hcl:"name,label"`
+	Extends string   `json:"extends" hcl:"extends,optional"`
+	Body    hcl.Body `json:"-" hcl:",remain"`
+}
+
+// named update strategy that services can refer to with how_to_update
+type UpdateStrategy struct {
+	Name           string `json:"name" hcl:"name,label"`
+	UpdateSettings `hcl:",remain"`
+	Rollback       *UpdateSettings `json:"rollback_config" hcl:"rollback_config,block"` // defaults to same as update
+}
+
+// durations are in Go's format ("10s", "1m30s")
+type UpdateSettings struct {
+	Parallelism     *uint64 `json:"parallelism" hcl:"parallelism,optional"`
+	Delay           string  `json:"delay" hcl:"delay,optional"`
+	Order           string  `json:"order" hcl:"order,optional"`                   // "start-first" | "stop-first"
+	FailureAction   string  `json:"failure_action" hcl:"failure_action,optional"` // "pause" | "continue" | "rollback"
+	Monitor         string  `json:"monitor" hcl:"monitor,optional"`
+	MaxFailureRatio float32 `json:"max_failure_ratio" hcl:"max_failure_ratio,optional"`
+}
+
+// durations are in Go's format ("10s", "1m30s")
+type Healthcheck struct {
+	Command     []string `json:"command" hcl:"command"` // exec form, without the "CMD" prefix
+	Interval    string   `json:"interval" hcl:"interval,optional"`
+	Timeout     string   `json:"timeout" hcl:"timeout,optional"`
+	Retries     *uint64  `json:"retries" hcl:"retries,optional"`
+	StartPeriod string   `json:"start_period" hcl:"start_period,optional"`
+}
+
+// durations are in Go's format ("10s", "1m30s")
+type RestartPolicy struct {
+	Condition   string  `json:"condition" hcl:"condition,optional"` // "none" | "on-failure" | "any"
+	Delay       string  `json:"delay" hcl:"delay,optional"`
+	MaxAttempts *uint64 `json:"max_attempts" hcl:"max_attempts,optional"`
+	Window      string  `json:"window" hcl:"window,optional"`
+}
+
 type Port struct {
-	Public    uint32 `json:"public" hcl:"public"`
-	Container uint32 `json:"container" hcl:"container"`
+	Public    uint32  `json:"public" hcl:"public"`
+	Container uint32  `json:"container" hcl:"container"`
+	Mode      string  `json:"mode" hcl:"mode,optional"`   // "ingress" (default, routing mesh) | "host" (sees client IPs)
+	Count     *uint32 `json:"count" hcl:"count,optional"` // publish range public..public+count-1 => container..container+count-1
 }
 
 type PersistentVolume struct {
@@ -69,6 +190,57 @@ type BindMount struct {
 	ReadOnly  bool   `json:"readonly" hcl:"readonly"`
 }
 
+// in-memory filesystem, content is lost when the container stops
+type Tmpfs struct {
+	Target string  `json:"target" hcl:"target"`
+	SizeMb *uint64 `json:"size_mb" hcl:"size_mb,optional"` // defaults to Docker's (unlimited)
+}
+
+type Ulimit struct {
+	Name string `json:"name" hcl:"name,label"` // "nofile"
+	Soft int    `json:"soft" hcl:"soft"`
+	Hard int    `json:"hard" hcl:"hard"`
+}
+
+type Sysctl struct {
+	Key   string `json:"key" hcl:"key,label"` // "net.core.somaxconn"
+	Value string `json:"value" hcl:"value"`
+}
+
+// entry in container's /etc/hosts
+type ExtraHost struct {
+	Hostname string `json:"hostname" hcl:"hostname,label"`
+	IP       string `json:"ip" hcl:"ip"`
+}
+
+// Swarm secret or config, whose content is read from a local file
+type FileObjectMount struct {
+	Name   string `json:"name" hcl:"name,label"`
+	File   string `json:"file" hcl:"file"`              // relative to the spec file
+	Target string `json:"target" hcl:"target,optional"` // defaults to /run/secrets/<name> or /<name>
+}
+
+type FileObjectKind string
+
+const (
+	FileObjectKindSecret FileObjectKind = "secret"
+	FileObjectKindConfig FileObjectKind = "config"
+)
+
+// Swarm secrets and configs are immutable, so their names are suffixed with content hash.
+// these need to exist in Swarm before deploying a stack that references them.
+type FileObject struct {
+	Kind    FileObjectKind
+	Name    string // "db_password-4f2a9c01de"
+	Content []byte
+}
+
+// cluster's conventions, for things that specs don't define
 type Defaults struct {
-	DockerNetworkName string
+	DockerNetworkName string            // network that services join
+	HowToUpdate       string            // for services that don't define one
+	RamMb             *uint64           // for services that don't define one
+	Labels            map[string]string // added to each service. spec-generated labels take precedence
+	LoggingDriver     string            // Docker's default if empty
+	LoggingOptions    map[string]string
 }
@@ -0,0 +1,498 @@
+package servicespec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Services (and templates) can extend a template. Merging is done on the HCL level before
+// decoding, so templates don't need to define required attributes. when both define something:
+//
+// - scalars override: attributes (except lists) and single blocks (backup, healthcheck etc.)
+// - lists append: list attributes (caps, devices) and unlabeled blocks (tcp_port, bindmount etc.)
+// - maps merge: labeled blocks (env "KEY", secret "name" etc.), where extending one wins
+//
+// templates can be defined in the spec file or in the cluster's shared templates directory.
+
+var templateSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "template", LabelNames: []string{"name"}},
+	},
+}
+
+var extendsSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "extends"},
+	},
+}
+
+type templateMergeRules struct {
+	listAttributes map[string]bool
+	labeledBlocks  map[string]bool
+	singleBlocks   map[string]bool
+}
+
+// derived from ServiceSpec so new fields get the correct merge behaviour automatically
+var serviceMergeRules = mergeRulesFor(reflect.TypeOf(ServiceSpec{}))
+
+type serviceTemplate struct {
+	name         string
+	extends      string
+	extendsRange hcl.Range
+	body         hcl.Body // without "extends"
+	defRange     hcl.Range
+}
+
+// reads template blocks of spec file and the shared templates directory (if it exists)
+func readTemplates(specBody hcl.Body, sharedTemplatesDir string) (map[string]*serviceTemplate, hcl.Diagnostics) {
+	templates := map[string]*serviceTemplate{}
+
+	add := func(body hcl.Body, partial bool) hcl.Diagnostics {
+		list, diags := readTemplateBlocks(body, partial)
+		for _, template := range list {
+			if existing, found := templates[template.name]; found {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Duplicate template",
+					Detail: fmt.Sprintf(
+						"Template %q was already defined at %s.",
+						template.name,
+						existing.defRange.String()),
+					Subject: template.defRange.Ptr(),
+				})
+				continue
+			}
+
+			templates[template.name] = template
+		}
+
+		return diags
+	}
+
+	sharedFiles, err := sharedTemplateFiles(sharedTemplatesDir)
+	if err != nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to read shared templates",
+			Detail:   err.Error(),
+		}}
+	}
+
+	diags := hcl.Diagnostics{}
+
+	for _, sharedFile := range sharedFiles {
+		content, err := ioutil.ReadFile(sharedFile)
+		if err != nil {
+			return nil, append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Failed to read shared templates",
+				Detail:   err.Error(),
+			})
+		}
+
+		file, fileDiags := hclsyntax.ParseConfig(content, sharedFile, hcl.Pos{Line: 1, Column: 1})
+		if fileDiags.HasErrors() {
+			return nil, append(diags, fileDiags...)
+		}
+
+		// shared files can only contain templates
+		diags = append(diags, add(file.Body, false)...)
+	}
+
+	diags = append(diags, add(specBody, true)...)
+
+	return templates, diags
+}
+
+// "*.hcl" files in the directory, sorted. directory not existing is not an error
+func sharedTemplateFiles(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".hcl" {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func readTemplateBlocks(body hcl.Body, partial bool) ([]*serviceTemplate, hcl.Diagnostics) {
+	var content *hcl.BodyContent
+	var diags hcl.Diagnostics
+	if partial {
+		content, _, diags = body.PartialContent(templateSchema)
+	} else {
+		content, diags = body.Content(templateSchema)
+	}
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	templates := []*serviceTemplate{}
+
+	for _, block := range content.Blocks {
+		template := &serviceTemplate{
+			name:     block.Labels[0],
+			defRange: block.DefRange,
+		}
+
+		extends, remain, extendsDiags := block.Body.PartialContent(extendsSchema)
+		diags = append(diags, extendsDiags...)
+		template.body = remain
+
+		if attr, has := extends.Attributes["extends"]; has {
+			diags = append(diags, gohcl.DecodeExpression(attr.Expr, nil, &template.extends)...)
+			template.extendsRange = attr.Expr.Range()
+		}
+
+		templates = append(templates, template)
+	}
+
+	return templates, diags
+}
+
+// wraps spec file's body so that bodies of services that extend a template are merged with it
+type templatedSpecBody struct {
+	hcl.Body
+	templates map[string]*serviceTemplate
+}
+
+func (b *templatedSpecBody) Content(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Diagnostics) {
+	content, diags := b.Body.Content(schema)
+	if diags.HasErrors() {
+		return content, diags
+	}
+
+	return content, append(diags, b.applyTemplates(content)...)
+}
+
+func (b *templatedSpecBody) PartialContent(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	content, remain, diags := b.Body.PartialContent(schema)
+	if diags.HasErrors() {
+		return content, remain, diags
+	}
+
+	return content, remain, append(diags, b.applyTemplates(content)...)
+}
+
+func (b *templatedSpecBody) applyTemplates(content *hcl.BodyContent) hcl.Diagnostics {
+	diags := hcl.Diagnostics{}
+
+	for idx, block := range content.Blocks {
+		if block.Type != "service" && block.Type != "global_service" && block.Type != "cron_job" {
+			continue
+		}
+
+		extends, _, extendsDiags := block.Body.PartialContent(extendsSchema)
+		diags = append(diags, extendsDiags...)
+
+		attr, has := extends.Attributes["extends"]
+		if !has {
+			continue
+		}
+
+		templateName := ""
+		if nameDiags := gohcl.DecodeExpression(attr.Expr, nil, &templateName); nameDiags.HasErrors() {
+			diags = append(diags, nameDiags...)
+			continue
+		}
+
+		base, resolveDiags := b.resolve(templateName, attr.Expr.Range(), map[string]bool{})
+		if resolveDiags.HasErrors() {
+			diags = append(diags, resolveDiags...)
+			continue
+		}
+
+		merged := *block // don't mutate the underlying body's blocks
+		merged.Body = &mergedBody{base: base, override: block.Body}
+		content.Blocks[idx] = &merged
+	}
+
+	return diags
+}
+
+// returns template's body merged with the templates it extends
+func (b *templatedSpecBody) resolve(name string, subject hcl.Range, visiting map[string]bool) (hcl.Body, hcl.Diagnostics) {
+	template, found := b.templates[name]
+	if !found {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unknown template",
+			Detail:   fmt.Sprintf("Template %q is not defined in the spec or shared templates.", name),
+			Subject:  &subject,
+		}}
+	}
+
+	if visiting[name] {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Template inheritance cycle",
+			Detail:   fmt.Sprintf("Template %q extends itself via %q.", name, template.extends),
+			Subject:  &subject,
+		}}
+	}
+	visiting[name] = true
+
+	if template.extends == "" {
+		return template.body, nil
+	}
+
+	base, diags := b.resolve(template.extends, template.extendsRange, visiting)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return &mergedBody{base: base, override: template.body}, nil
+}
+
+// body of a service (or template) merged with the template it extends
+type mergedBody struct {
+	base     hcl.Body
+	override hcl.Body
+}
+
+func (b *mergedBody) Content(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Diagnostics) {
+	content, _, diags := b.content(schema, false)
+	return content, diags
+}
+
+func (b *mergedBody) PartialContent(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	return b.content(schema, true)
+}
+
+func (b *mergedBody) JustAttributes() (hcl.Attributes, hcl.Diagnostics) {
+	attrs, diags := b.base.JustAttributes()
+	if attrs == nil {
+		attrs = hcl.Attributes{}
+	}
+
+	overrideAttrs, overrideDiags := b.override.JustAttributes()
+	for name, attr := range overrideAttrs {
+		attrs[name] = attr
+	}
+
+	return attrs, append(diags, overrideDiags...)
+}
+
+func (b *mergedBody) MissingItemRange() hcl.Range {
+	return b.override.MissingItemRange()
+}
+
+func (b *mergedBody) content(schema *hcl.BodySchema, partial bool) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	// required attributes are checked after merging, as either side can define them
+	relaxed := &hcl.BodySchema{Blocks: schema.Blocks}
+	for _, attrSchema := range schema.Attributes {
+		relaxed.Attributes = append(relaxed.Attributes, hcl.AttributeSchema{Name: attrSchema.Name})
+	}
+
+	bodyContent := func(body hcl.Body) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+		if partial {
+			return body.PartialContent(relaxed)
+		}
+
+		content, diags := body.Content(relaxed)
+		return content, nil, diags
+	}
+
+	baseContent, baseRemain, diags := bodyContent(b.base)
+	overrideContent, overrideRemain, overrideDiags := bodyContent(b.override)
+	diags = append(diags, overrideDiags...)
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+
+	merged := &hcl.BodyContent{
+		Attributes:       hcl.Attributes{},
+		Blocks:           mergeBlocks(baseContent.Blocks, overrideContent.Blocks),
+		MissingItemRange: overrideContent.MissingItemRange,
+	}
+
+	for name, attr := range baseContent.Attributes {
+		merged.Attributes[name] = attr
+	}
+
+	for name, attr := range overrideContent.Attributes {
+		if baseAttr, inherited := merged.Attributes[name]; inherited && serviceMergeRules.listAttributes[name] {
+			attr = &hcl.Attribute{
+				Name:      attr.Name,
+				Expr:      &concatExpr{base: baseAttr.Expr, override: attr.Expr},
+				Range:     attr.Range,
+				NameRange: attr.NameRange,
+			}
+		}
+
+		merged.Attributes[name] = attr
+	}
+
+	for _, attrSchema := range schema.Attributes {
+		if _, has := merged.Attributes[attrSchema.Name]; attrSchema.Required && !has {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Missing required argument",
+				Detail: fmt.Sprintf(
+					"The argument %q is required, but was not set (nor inherited from a template).",
+					attrSchema.Name),
+				Subject: merged.MissingItemRange.Ptr(),
+			})
+		}
+	}
+
+	var remain hcl.Body
+	if partial {
+		remain = &mergedBody{base: baseRemain, override: overrideRemain}
+	}
+
+	return merged, remain, diags
+}
+
+func mergeBlocks(base hcl.Blocks, override hcl.Blocks) hcl.Blocks {
+	overridden := func(block *hcl.Block) bool {
+		for _, overrideBlock := range override {
+			if overrideBlock.Type != block.Type {
+				continue
+			}
+
+			if serviceMergeRules.singleBlocks[block.Type] {
+				return true
+			}
+
+			if serviceMergeRules.labeledBlocks[block.Type] && overrideBlock.Labels[0] == block.Labels[0] {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	merged := hcl.Blocks{}
+	for _, block := range base {
+		if !overridden(block) {
+			merged = append(merged, block)
+		}
+	}
+
+	return append(merged, override...)
+}
+
+func mergeRulesFor(typ reflect.Type) templateMergeRules {
+	rules := templateMergeRules{
+		listAttributes: map[string]bool{},
+		labeledBlocks:  map[string]bool{},
+		singleBlocks:   map[string]bool{},
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		name, kind := parseHclTag(field.Tag.Get("hcl"))
+
+		switch kind {
+		case "attr", "optional":
+			if field.Type.Kind() == reflect.Slice {
+				rules.listAttributes[name] = true
+			}
+		case "block":
+			switch {
+			case field.Type.Kind() != reflect.Slice:
+				rules.singleBlocks[name] = true
+			case len(hclLabelFields(field.Type.Elem())) > 0:
+				rules.labeledBlocks[name] = true
+			}
+		}
+	}
+
+	return rules
+}
+
+func hclLabelFields(typ reflect.Type) []string {
+	labels := []string{}
+	for i := 0; i < typ.NumField(); i++ {
+		if name, kind := parseHclTag(typ.Field(i).Tag.Get("hcl")); kind == "label" {
+			labels = append(labels, name)
+		}
+	}
+
+	return labels
+}
+
+// list attribute defined both in template and in the one extending it: ["a"] + ["b"] => ["a", "b"]
+type concatExpr struct {
+	base     hcl.Expression
+	override hcl.Expression
+}
+
+var _ hcl.Expression = (*concatExpr)(nil)
+
+func (e *concatExpr) Value(ctx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+	baseVal, diags := e.base.Value(ctx)
+	overrideVal, overrideDiags := e.override.Value(ctx)
+	diags = append(diags, overrideDiags...)
+	if diags.HasErrors() {
+		return cty.DynamicVal, diags
+	}
+
+	if baseVal.IsNull() {
+		return overrideVal, nil
+	}
+	if overrideVal.IsNull() {
+		return baseVal, nil
+	}
+
+	if !baseVal.IsWhollyKnown() || !overrideVal.IsWhollyKnown() {
+		return cty.DynamicVal, nil
+	}
+
+	for _, val := range []cty.Value{baseVal, overrideVal} {
+		if ty := val.Type(); !(ty.IsListType() || ty.IsTupleType() || ty.IsSetType()) {
+			return cty.DynamicVal, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Cannot append to inherited value",
+				Detail:   fmt.Sprintf("Expecting lists, got %s.", strings.ToLower(ty.FriendlyName())),
+				Subject:  e.Range().Ptr(),
+			}}
+		}
+	}
+
+	elements := append(baseVal.AsValueSlice(), overrideVal.AsValueSlice()...)
+	if len(elements) == 0 {
+		return cty.EmptyTupleVal, nil
+	}
+
+	return cty.TupleVal(elements), nil
+}
+
+func (e *concatExpr) Variables() []hcl.Traversal {
+	return append(e.base.Variables(), e.override.Variables()...)
+}
+
+func (e *concatExpr) Range() hcl.Range {
+	return e.override.Range()
+}
+
+func (e *concatExpr) StartRange() hcl.Range {
+	return e.override.StartRange()
+}
@@ -0,0 +1,22 @@
+rule "no-privileged" {
+  check = "privileged"
+}
+
+rule "no-dangerous-caps" {
+  check = "caps"
+  values = ["SYS_ADMIN", "CAP_NET_ADMIN"] # either form works
+}
+
+rule "no-docker-socket" {
+  check = "bind_mount"
+  values = ["/var/run/docker.sock"]
+  description = "gives root on the host"
+}
+
+rule "no-host-namespaces" {
+  check = "host_namespaces"
+}
+
+rule "no-public-stateful" {
+  check = "public_ingress_with_persistent_volumes"
+}
@@ -0,0 +1,57 @@
+service "portainer" {
+  image = "portainer/portainer"
+  version = "1.23.2"
+  how_to_update = "stop-old-first"
+  ram_mb = 64
+
+  bindmount {
+    host = "/var/run"
+    container = "/var/run"
+    readonly = false
+  }
+}
+
+service "vpn" {
+  image = "joonas/vpn"
+  version = "v1"
+  how_to_update = "stop-old-first"
+  ram_mb = 64
+  caps = ["CAP_NET_ADMIN", "SYS_ADMIN", "SYS_TIME"]
+}
+
+service "wiki" {
+  image = "joonas/wiki"
+  version = "v1"
+  how_to_update = "stop-old-first"
+  ram_mb = 64
+  placement_node_hostname = "box1"
+
+  persistentvolume {
+    name = "wiki"
+    target = "/data"
+  }
+
+  backup {
+    command = "cat /data/wiki.db"
+  }
+
+  ingress "site" {
+    auth = "public"
+    rule = "Host:wiki.example.com"
+    port = 80
+  }
+}
+
+global_service "agent" {
+  image = "joonas/agent"
+  version = "v1"
+  how_to_update = "stop-old-first"
+  ram_mb = 64
+  privileged = true
+  net_host = true
+  pid_host = true
+
+  policy_exemption "no-privileged" {
+    reason = "needs to manage host's devices"
+  }
+}
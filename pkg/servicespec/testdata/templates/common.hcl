@@ -0,0 +1,9 @@
+template "fn61-defaults" {
+  how_to_update = "stop-old-first"
+  ram_mb = 32
+  caps = ["NET_BIND_SERVICE"]
+
+  env "LOGLEVEL" {
+    value = "info"
+  }
+}
@@ -0,0 +1,83 @@
+update_strategy "parallel-one-at-a-time" {
+  parallelism = 2
+}
+
+service "unknownstrategy" {
+  image = "joonas/hellohttp"
+  version = "v2"
+  how_to_update = "yolo"
+  ram_mb = 16
+}
+
+service "twoingresses" {
+  image = "joonas/hellohttp"
+  version = "v2"
+  how_to_update = "parallel-one-at-a-time"
+  ram_mb = 16
+
+  ingress_public {
+    rule = "Host:hellohttp.com"
+  }
+
+  ingress_sso {
+    rule = "Host:admin.hellohttp.com"
+    users = ["joonas"]
+    tenant = "fn61"
+  }
+}
+
+service "statefulwithoutplacement" {
+  image = "postgres"
+  version = "12"
+  how_to_update = "stop-old-first"
+  ram_mb = 128
+
+  persistentvolume {
+    name = "pgdata"
+    target = "/var/lib/postgresql/data"
+  }
+}
+
+service "ramreservation" {
+  image = "joonas/hellohttp"
+  version = "v2"
+  how_to_update = "parallel-one-at-a-time"
+  ram_mb = 16
+  ram_reservation_mb = 32
+}
+
+global_service "agent" {
+  image = "prom/node-exporter"
+  version = "v1"
+  how_to_update = "parallel-one-at-a-time"
+  ram_mb = 16
+  replicas = 2
+}
+
+service "collidingingresses" {
+  image = "joonas/hellohttp"
+  version = "v2"
+  how_to_update = "parallel-one-at-a-time"
+  ram_mb = 16
+
+  ingress "site" {
+    auth = "public"
+    rule = "Host:hellohttp.com,www.hellohttp.com"
+    port = 80
+  }
+
+  ingress "admin" {
+    auth = "sso"
+    rule = "Host: www.hellohttp.com, hellohttp.com"
+    port = 8080
+  }
+}
+
+cron_job "cleanup" {
+  schedule = "every night"
+  image = "joonas/cleanup"
+  version = "v1"
+  how_to_update = "stop-old-first"
+  ram_mb = 16
+  timeout = "1h"
+}
@@ -0,0 +1,114 @@
+package servicespec
+
+import (
+	"fmt"
+
+	composetypes "github.com/docker/cli/cli/compose/types"
+)
+
+type updateStrategy struct {
+	update   *composetypes.UpdateConfig
+	rollback *composetypes.UpdateConfig // nil => Swarm defaults
+}
+
+var one = uint64(1)
+var knownUpdateConfigs = map[string]*composetypes.UpdateConfig{
+	"parallel-one-at-a-time": {
+		Parallelism: &one,
+		Order:       "start-first",
+	},
+	"stop-old-first": {
+		Order: "stop-first",
+	},
+}
+
+// built-in strategies + ones defined in the spec file
+func resolveUpdateStrategies(userDefined []UpdateStrategy) (map[string]updateStrategy, error) {
+	strategies := map[string]updateStrategy{}
+
+	for name, updateConfig := range knownUpdateConfigs {
+		strategies[name] = updateStrategy{update: updateConfig}
+	}
+
+	for _, strategy := range userDefined {
+		if _, alreadyDefined := strategies[strategy.Name]; alreadyDefined {
+			return nil, fmt.Errorf("update_strategy %s: already defined", strategy.Name)
+		}
+
+		update, err := convertUpdateSettings(strategy.UpdateSettings, false)
+		if err != nil {
+			return nil, fmt.Errorf("update_strategy %s: %w", strategy.Name, err)
+		}
+
+		// rolling back with same settings we rolled out with is the least surprising default.
+		// a rollback can't be rolled back, so (like Swarm's default) its failure action is "pause"
+		rollbackSettings := strategy.UpdateSettings
+		if rollbackSettings.FailureAction == "rollback" {
+			rollbackSettings.FailureAction = ""
+		}
+		if strategy.Rollback != nil {
+			rollbackSettings = *strategy.Rollback
+		}
+
+		rollback, err := convertUpdateSettings(rollbackSettings, true)
+		if err != nil {
+			return nil, fmt.Errorf("update_strategy %s: rollback_config: %w", strategy.Name, err)
+		}
+
+		strategies[strategy.Name] = updateStrategy{
+			update:   update,
+			rollback: rollback,
+		}
+	}
+
+	return strategies, nil
+}
+
+func convertUpdateSettings(settings UpdateSettings, isRollback bool) (*composetypes.UpdateConfig, error) {
+	switch settings.Order {
+	case "", "start-first", "stop-first":
+	default:
+		return nil, fmt.Errorf("unsupported order: %s", settings.Order)
+	}
+
+	switch settings.FailureAction {
+	case "", "pause", "continue":
+	case "rollback":
+		if isRollback { // can't roll back a rollback
+			return nil, fmt.Errorf("unsupported failure_action: %s", settings.FailureAction)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported failure_action: %s", settings.FailureAction)
+	}
+
+	if settings.MaxFailureRatio < 0 || settings.MaxFailureRatio > 1 {
+		return nil, fmt.Errorf("max_failure_ratio not in range 0..1: %v", settings.MaxFailureRatio)
+	}
+
+	delay, err := parseOptionalDuration(settings.Delay)
+	if err != nil {
+		return nil, fmt.Errorf("delay: %w", err)
+	}
+
+	monitor, err := parseOptionalDuration(settings.Monitor)
+	if err != nil {
+		return nil, fmt.Errorf("monitor: %w", err)
+	}
+
+	updateConfig := &composetypes.UpdateConfig{
+		Parallelism:     settings.Parallelism,
+		Order:           settings.Order,
+		FailureAction:   settings.FailureAction,
+		MaxFailureRatio: settings.MaxFailureRatio,
+	}
+
+	if delay != nil {
+		updateConfig.Delay = *delay
+	}
+
+	if monitor != nil {
+		updateConfig.Monitor = *monitor
+	}
+
+	return updateConfig, nil
+}
@@ -0,0 +1,393 @@
+package servicespec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	composetypes "github.com/docker/cli/cli/compose/types"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// problem in a service that is detectable from the spec alone. subject is the name of the
+// attribute or block that the problem is about, so validation can point to its source location
+type specProblem struct {
+	subject string
+	message string
+}
+
+// these are what convertOneService() would stop at, but listing them all at once
+func checkService(
+	service ServiceSpec,
+	isGlobal bool,
+	updateStrategies map[string]updateStrategy,
+) []specProblem {
+	problems := []specProblem{}
+
+	// forcing user (or cluster defaults) explicitly to tell this because incorrect config is dangerous
+	if service.HowToUpdate == "" {
+		problems = append(problems, specProblem{
+			subject: "how_to_update",
+			message: "how_to_update not defined (and cluster has no default)"})
+	} else if _, found := updateStrategies[service.HowToUpdate]; !found {
+		problems = append(problems, specProblem{
+			subject: "how_to_update",
+			message: fmt.Sprintf("unknown HowToUpdate: %s", service.HowToUpdate)})
+	}
+
+	if service.RamMb == nil {
+		problems = append(problems, specProblem{
+			subject: "ram_mb",
+			message: "ram_mb not defined (and cluster has no default)"})
+	}
+
+	unnamedIngresses := []string{}
+	if service.IngressPublic != nil {
+		unnamedIngresses = append(unnamedIngresses, "ingress_public")
+	}
+	if service.IngressBearer != nil {
+		unnamedIngresses = append(unnamedIngresses, "ingress_bearer")
+	}
+	if service.IngressSso != nil {
+		unnamedIngresses = append(unnamedIngresses, "ingress_sso")
+	}
+
+	if len(unnamedIngresses) > 1 {
+		problems = append(problems, specProblem{
+			subject: unnamedIngresses[1], // first one is fine, point to the one that is too many
+			message: "maximum of one unnamed ingress per service exceeded (use named ingress blocks)"})
+	}
+
+	// unnamed one would use Traefik's default labels, which don't mix with segment labels
+	if len(unnamedIngresses) > 0 && len(service.Ingresses) > 0 {
+		problems = append(problems, specProblem{
+			subject: unnamedIngresses[0],
+			message: "cannot combine " + unnamedIngresses[0] + " with named ingress blocks"})
+	}
+
+	problems = append(problems, checkIngresses(service.Ingresses)...)
+
+	if isGlobal && service.Replicas != nil {
+		problems = append(problems, specProblem{
+			subject: "replicas",
+			message: "global services cannot have 'replicas' defined"})
+	}
+
+	// prevent human errors
+	if len(service.PersistentVolumes) > 0 && service.PlacementNodeHostname == "" {
+		problems = append(problems, specProblem{
+			subject: "persistentvolume",
+			message: "persistent volumes defined but no placement hostname defined"})
+	}
+
+	/*
+		there are stateful volumes that can be shared between concurrent containers (file uploads @ erotuomari.com)
+		if len(service.PersistentVolumes) > 0 && service.HowToUpdate != "stop-old-first" {
+			return errors.New("expecting stop-old-first when have PersistentVolumes")
+		}
+	*/
+
+	problems = append(problems, checkPorts(service.TcpPorts, "tcp_port", service, isGlobal, updateStrategies)...)
+	problems = append(problems, checkPorts(service.UdpPorts, "udp_port", service, isGlobal, updateStrategies)...)
+
+	if len(service.PersistentVolumes) > 0 && service.Backup == nil {
+		problems = append(problems, specProblem{
+			subject: "persistentvolume",
+			message: `stateful service - define at least empty backup section if you really don't want backups`})
+	}
+
+	return problems
+}
+
+func checkPorts(
+	ports []Port,
+	blockType string,
+	service ServiceSpec,
+	isGlobal bool,
+	updateStrategies map[string]updateStrategy,
+) []specProblem {
+	problems := []specProblem{}
+
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, specProblem{
+			subject: blockType,
+			message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, port := range ports {
+		switch port.Mode {
+		case "", portModeIngress:
+		case portModeHost:
+			// replicas would land on random nodes, so clients wouldn't know where to connect.
+			// (also two replicas on one node would conflict)
+			if !isGlobal && service.PlacementNodeHostname == "" {
+				report("%s %d: host mode requires placement_node_hostname (or global_service)", blockType, port.Public)
+			}
+
+			if !isGlobal && service.PlacementNodeHostname != "" && service.Replicas != nil && *service.Replicas > 1 {
+				report("%s %d: host mode with replicas > 1 on a single node (replicas would conflict over the port)", blockType, port.Public)
+			}
+
+			// new task can't bind the port while the old one still has it
+			if strategy, found := updateStrategies[service.HowToUpdate]; found && strategy.update.Order == "start-first" {
+				report("%s %d: host mode cannot be used with start-first how_to_update (%s)", blockType, port.Public, service.HowToUpdate)
+			}
+		default:
+			report("%s %d: unknown mode: %s", blockType, port.Public, port.Mode)
+		}
+
+		if port.Count != nil {
+			if *port.Count == 0 {
+				report("%s %d: count must be at least 1", blockType, port.Public)
+			} else if uint64(port.Public)+uint64(*port.Count)-1 > 65535 || uint64(port.Container)+uint64(*port.Count)-1 > 65535 {
+				report("%s %d: range exceeds port 65535", blockType, port.Public)
+			}
+		}
+	}
+
+	return problems
+}
+
+var ingressNameRe = regexp.MustCompile("^[a-z0-9-]+$")
+
+func checkIngresses(ingresses []Ingress) []specProblem {
+	problems := []specProblem{}
+
+	ingressProblem := func(ingress Ingress, format string, args ...interface{}) {
+		problems = append(problems, specProblem{
+			subject: "ingress " + ingress.Name,
+			message: fmt.Sprintf("ingress %s: ", ingress.Name) + fmt.Sprintf(format, args...)})
+	}
+
+	names := map[string]bool{}
+	rules := map[string]string{} // rule key => name of ingress that has it
+
+	for _, ingress := range ingresses {
+		// name ends up in label keys
+		if !ingressNameRe.MatchString(ingress.Name) {
+			ingressProblem(ingress, "name must match %s", ingressNameRe.String())
+		}
+
+		if names[ingress.Name] {
+			ingressProblem(ingress, "duplicate name")
+		}
+		names[ingress.Name] = true
+
+		switch ingress.Auth {
+		case ingressAuthPublic:
+		case ingressAuthBearerToken:
+			if ingress.Token == "" {
+				ingressProblem(ingress, "auth %s requires token", ingress.Auth)
+			}
+		case ingressAuthSso:
+			if ingress.Tenant == "" || len(ingress.Users) == 0 {
+				ingressProblem(ingress, "auth %s requires tenant and users", ingress.Auth)
+			}
+		default:
+			ingressProblem(ingress, "unknown auth: %s (supported: %s, %s, %s)",
+				ingress.Auth,
+				ingressAuthPublic,
+				ingressAuthBearerToken,
+				ingressAuthSso)
+		}
+
+		ruleKey := ingressRuleKey(ingress.Rule)
+		if other, collides := rules[ruleKey]; collides {
+			ingressProblem(ingress, "rule collides with ingress %s", other)
+		} else {
+			rules[ruleKey] = ingress.Name
+		}
+	}
+
+	return problems
+}
+
+// unlike SpecToComposeByPath(), does not stop at the first problem. returned file (nil if
+// the file could not be parsed) is for rendering source snippets of the diagnostics.
+func ValidateSpecByPath(path string, clusterCtx ClusterContext) (*hcl.File, hcl.Diagnostics) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to read spec file",
+			Detail:   err.Error(),
+		}}
+	}
+
+	return validateSpec(
+		content,
+		path,
+		VarOverridesPath(path, clusterCtx.ID),
+		filepath.Dir(path),
+		clusterCtx)
+}
+
+func validateSpec(
+	content []byte,
+	filename string,
+	varOverridesPath string,
+	specDir string,
+	clusterCtx ClusterContext,
+) (*hcl.File, hcl.Diagnostics) {
+	spec, file, diags := decodeSpecFile(content, filename, varOverridesPath, specDir, clusterCtx)
+	if diags.HasErrors() {
+		return file, diags
+	}
+
+	body := file.Body.(*hclsyntax.Body)
+
+	// validate strategies one by one so one broken strategy does not hide problems elsewhere
+	validStrategies := []UpdateStrategy{}
+	for _, strategy := range spec.UpdateStrategies {
+		if _, err := resolveUpdateStrategies(append(validStrategies, strategy)); err != nil {
+			diags = append(diags, specDiagnostic(err.Error(), blockRange(body, "update_strategy", strategy.Name)))
+			continue
+		}
+
+		validStrategies = append(validStrategies, strategy)
+	}
+
+	updateStrategies, err := resolveUpdateStrategies(validStrategies)
+	if err != nil { // should not happen, as each was validated above
+		return file, append(diags, specDiagnostic(err.Error(), body.SrcRange))
+	}
+
+	convCtx := conversionContext{
+		specDir:          specDir,
+		updateStrategies: updateStrategies,
+		cluster:          clusterCtx,
+	}
+
+	// convert is only run if there are no problems, as conversion would just stop at the first one
+	validateBlock := func(blockType string, name string, problems []specProblem, convert func(*composetypes.Config) error) {
+		block, found := findBlock(body, blockType, name)
+
+		problemRange := func(subject string) hcl.Range {
+			if !found {
+				return body.SrcRange
+			}
+
+			return subjectRange(block, subject)
+		}
+
+		for _, problem := range problems {
+			diags = append(diags, specDiagnostic(
+				fmt.Sprintf("%s %s: %s", blockType, name, problem.message),
+				problemRange(problem.subject)))
+		}
+
+		if len(problems) > 0 {
+			return
+		}
+
+		// catches the rest (resources, healthcheck, secret files etc.)
+		scratch := &composetypes.Config{
+			Volumes:  map[string]composetypes.VolumeConfig{},
+			Networks: map[string]composetypes.NetworkConfig{},
+			Secrets:  map[string]composetypes.SecretConfig{},
+			Configs:  map[string]composetypes.ConfigObjConfig{},
+		}
+
+		if err := convert(scratch); err != nil {
+			diags = append(diags, specDiagnostic(
+				fmt.Sprintf("%s %s: %s", blockType, name, err.Error()),
+				blockRange(body, blockType, name)))
+		}
+	}
+
+	validateServices := func(services []ServiceSpec, blockType string, isGlobal bool) {
+		for _, service := range services {
+			service := service // pin
+
+			problems := checkService(withDefaults(service, clusterCtx.Defaults), isGlobal, updateStrategies)
+
+			validateBlock(blockType, service.Name, problems, func(scratch *composetypes.Config) error {
+				_, err := convertOneService(service, isGlobal, scratch, convCtx)
+				return err
+			})
+		}
+	}
+
+	validateServices(spec.Services, "service", false)
+	validateServices(spec.GlobalServices, "global_service", true)
+
+	for _, job := range spec.CronJobs {
+		job := job // pin
+
+		problems := append(
+			checkCronJob(job),
+			checkService(withDefaults(cronJobService(job), clusterCtx.Defaults), false, updateStrategies)...)
+
+		validateBlock("cron_job", job.Name, problems, func(scratch *composetypes.Config) error {
+			_, err := convertCronJob(job, scratch, convCtx)
+			return err
+		})
+	}
+
+	return file, diags
+}
+
+// fills in what the spec left for the cluster to decide
+func withDefaults(service ServiceSpec, defaults Defaults) ServiceSpec {
+	if service.HowToUpdate == "" {
+		service.HowToUpdate = defaults.HowToUpdate
+	}
+
+	if service.RamMb == nil {
+		service.RamMb = defaults.RamMb
+	}
+
+	return service
+}
+
+func specDiagnostic(summary string, subject hcl.Range) *hcl.Diagnostic {
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  summary,
+		Subject:  &subject,
+	}
+}
+
+func findBlock(body *hclsyntax.Body, blockType string, name string) (*hclsyntax.Block, bool) {
+	for _, block := range body.Blocks {
+		if block.Type == blockType && len(block.Labels) > 0 && block.Labels[0] == name {
+			return block, true
+		}
+	}
+
+	return nil, false
+}
+
+// block's definition. falls back to the whole body if block is not found
+func blockRange(body *hclsyntax.Body, blockType string, name string) hcl.Range {
+	if block, found := findBlock(body, blockType, name); found {
+		return block.DefRange()
+	}
+
+	return body.SrcRange
+}
+
+// subject is an attribute name, block type or "<block type> <label>". falls back to the
+// block's own range if subject is not found
+func subjectRange(block *hclsyntax.Block, subject string) hcl.Range {
+	if attr, found := block.Body.Attributes[subject]; found {
+		return attr.Expr.Range()
+	}
+
+	subjectParts := strings.SplitN(subject, " ", 2)
+
+	for _, child := range block.Body.Blocks {
+		if child.Type != subjectParts[0] {
+			continue
+		}
+
+		if len(subjectParts) == 1 || (len(child.Labels) > 0 && child.Labels[0] == subjectParts[1]) {
+			return child.DefRange()
+		}
+	}
+
+	return block.DefRange()
+}
@@ -0,0 +1,46 @@
+package servicespec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+func TestValidateSpec(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/validateProblems.hcl")
+	assert.Assert(t, err == nil)
+
+	_, diags := validateSpec(content, "validateProblems.hcl", "testdata/nonexistent.vars", "testdata", testClusterContext)
+
+	problems := []string{}
+	for _, diag := range diags {
+		problems = append(problems, fmt.Sprintf("%s: %s", diag.Subject, diag.Summary))
+	}
+
+	assert.EqualString(t, strings.Join(problems, "\n"), `validateProblems.hcl:1,1-43: update_strategy parallel-one-at-a-time: already defined
+validateProblems.hcl:8,19-25: service unknownstrategy: unknown HowToUpdate: yolo
+validateProblems.hcl:22,3-16: service twoingresses: maximum of one unnamed ingress per service exceeded (use named ingress blocks)
+validateProblems.hcl:35,3-21: service statefulwithoutplacement: persistent volumes defined but no placement hostname defined
+validateProblems.hcl:35,3-21: service statefulwithoutplacement: stateful service - define at least empty backup section if you really don't want backups
+validateProblems.hcl:41,1-27: service ramreservation: ram_reservation_mb (32) larger than ram_mb (16)
+validateProblems.hcl:69,3-20: service collidingingresses: ingress admin: auth sso requires tenant and users
+validateProblems.hcl:69,3-20: service collidingingresses: ingress admin: rule collides with ingress site
+validateProblems.hcl:54,14-15: global_service agent: global services cannot have 'replicas' defined
+validateProblems.hcl:77,14-27: cron_job cleanup: schedule: expecting 5 fields (minute hour day month weekday); got 2
+validateProblems.hcl:82,13-17: cron_job cleanup: timeout requires command to be defined (image's entrypoint is replaced with timeout)`)
+}
+
+func TestBlockRangeNotFound(t *testing.T) {
+	file, diags := hclsyntax.ParseConfig([]byte(`service "web" {}`), "spec.hcl", hcl.Pos{Line: 1, Column: 1})
+	assert.Assert(t, !diags.HasErrors())
+
+	body := file.Body.(*hclsyntax.Body)
+
+	assert.EqualString(t, blockRange(body, "service", "web").String(), "spec.hcl:1,1-16")
+	assert.EqualString(t, blockRange(body, "service", "api").String(), "spec.hcl:1,1-17")
+}
@@ -0,0 +1,219 @@
+package servicespec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+// where the spec is being deployed to. exposed to specs as variables so one spec can be
+// deployed to many clusters.
+type ClusterContext struct {
+	ID             string // "prod5"
+	Domain         string // "fn61.net"
+	JamesRef       string // "prod5:stacks/hellohttp.hcl"
+	IngressDialect IngressDialect
+	Defaults       Defaults
+	// deprecated. ubackup versions that predate labels read the command from BACKUP_COMMAND env
+	LegacyBackupCommandEnv bool
+	TemplatesDir           string // shared templates (*.hcl) usable by all specs. optional
+}
+
+// "prod5:stacks/hellohttp.hcl" => "hellohttp". "" if james ref is not known
+func (c ClusterContext) stackName() string {
+	if c.JamesRef == "" {
+		return ""
+	}
+
+	specPath := c.JamesRef[strings.Index(c.JamesRef, ":")+1:]
+
+	return strings.TrimSuffix(filepath.Base(specPath), filepath.Ext(specPath))
+}
+
+// "stacks/hellohttp.hcl" => "stacks/hellohttp.prod5.vars"
+func VarOverridesPath(specPath string, clusterId string) string {
+	return strings.TrimSuffix(specPath, filepath.Ext(specPath)) + "." + clusterId + ".vars"
+}
+
+// evaluation context without "var", which is used for evaluating variables themselves
+func baseEvalContext(specDir string, clusterCtx ClusterContext) *hcl.EvalContext {
+	return &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"cluster": cty.ObjectVal(map[string]cty.Value{
+				"id":     cty.StringVal(clusterCtx.ID),
+				"domain": cty.StringVal(clusterCtx.Domain),
+			}),
+			"james": cty.ObjectVal(map[string]cty.Value{
+				"ref": cty.StringVal(clusterCtx.JamesRef),
+			}),
+		},
+		Functions: map[string]function.Function{
+			"upper":  stdlib.UpperFunc,
+			"lower":  stdlib.LowerFunc,
+			"format": stdlib.FormatFunc,
+			"join":   joinFunc,
+			"file":   makeFileFunc(specDir),
+		},
+	}
+}
+
+// resolves values of spec's "variable" blocks, and returns evaluation context for rest of the spec
+func evalContextWithVariables(
+	body hcl.Body,
+	varOverridesPath string,
+	specDir string,
+	clusterCtx ClusterContext,
+) (*hcl.EvalContext, hcl.Diagnostics) {
+	baseCtx := baseEvalContext(specDir, clusterCtx)
+
+	content, _, diags := body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "variable", LabelNames: []string{"name"}},
+		},
+	})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	overrides, diags := readVarOverrides(varOverridesPath)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	values := map[string]cty.Value{}
+
+	for _, block := range content.Blocks {
+		variable := Variable{}
+		if diags := gohcl.DecodeBody(block.Body, baseCtx, &variable); diags.HasErrors() {
+			return nil, diags
+		}
+		variable.Name = block.Labels[0]
+
+		if _, alreadyDefined := values[variable.Name]; alreadyDefined {
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Duplicate variable",
+				Detail:   fmt.Sprintf("Variable %q was already defined.", variable.Name),
+				Subject:  block.DefRange.Ptr(),
+			}}
+		}
+
+		valueExpr := variable.Default
+		if override, has := overrides[variable.Name]; has {
+			valueExpr = override.Expr
+		}
+
+		value, diags := valueExpr.Value(baseCtx)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+
+		if value.IsNull() {
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Missing variable value",
+				Detail: fmt.Sprintf(
+					"Variable %q has no default, and %s does not set it.",
+					variable.Name,
+					varOverridesPath),
+				Subject: block.DefRange.Ptr(),
+			}}
+		}
+
+		values[variable.Name] = value
+	}
+
+	// catch typos in override files
+	for name, override := range overrides {
+		if _, defined := values[name]; !defined {
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Undeclared variable",
+				Detail:   fmt.Sprintf("Override for %q, but the spec has no such variable.", name),
+				Subject:  override.NameRange.Ptr(),
+			}}
+		}
+	}
+
+	ctx := baseCtx.NewChild()
+	ctx.Variables = map[string]cty.Value{
+		"var": cty.ObjectVal(values),
+	}
+
+	return ctx, nil
+}
+
+// missing file is not an error, since overrides are optional
+func readVarOverrides(path string) (hcl.Attributes, hcl.Diagnostics) {
+	if path == "" {
+		return hcl.Attributes{}, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return hcl.Attributes{}, nil
+		}
+
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to read variable overrides",
+			Detail:   err.Error(),
+		}}
+	}
+
+	file, diags := hclsyntax.ParseConfig(content, path, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return file.Body.JustAttributes()
+}
+
+var joinFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "separator", Type: cty.String},
+		{Name: "list", Type: cty.List(cty.String)},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		items := []string{}
+		for _, item := range args[1].AsValueSlice() {
+			items = append(items, item.AsString())
+		}
+
+		return cty.StringVal(strings.Join(items, args[0].AsString())), nil
+	},
+})
+
+// reads file relative to the spec file
+func makeFileFunc(specDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "path", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path := args[0].AsString()
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(specDir, path)
+			}
+
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return cty.NilVal, err
+			}
+
+			return cty.StringVal(string(content)), nil
+		},
+	})
+}